@@ -0,0 +1,238 @@
+package fmp
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// applyAutoEnter fills in the auto-enter values of a record that is being
+// created, or of one that is being updated, in which case changed holds the
+// fields that are changed. It returns the fields whose values it set.
+//
+// Like in FileMaker, creation values are only entered into new records, and
+// only if no value is given. Modification values are entered on every change.
+// Auto-enter calculations are evaluated for new records, and for updated
+// records when a field they refer to changes. Values given for new records are
+// kept. On updates, calculations that replace the existing value are always
+// entered, others only into empty fields. Unless the field says otherwise,
+// they are not evaluated if all fields of the record they refer to are empty.
+// Calculations that cannot be evaluated in Go are skipped.
+func (tx *FmpTransaction) applyAutoEnter(record *FmpRecord, columns []*FmpColumn, changed map[uint64]bool) ([]*FmpColumn, error) {
+	ctx := tx.file
+	if ctx.noAutoEnter {
+		return nil, nil
+	}
+
+	creating := changed == nil
+	now := time.Now()
+	if ctx.now != nil {
+		now = ctx.now()
+	}
+
+	set := make([]*FmpColumn, 0)
+	enter := func(column *FmpColumn, value string) {
+		record.Values[column.Index] = value
+		set = append(set, column)
+	}
+
+	for _, column := range columns {
+		if !column.autoEnters || column.Type != FmpFieldSimple {
+			continue
+		}
+		empty := record.Values[column.Index] == ""
+
+		switch column.AutoEnter {
+		case FmpAutoEnterSerialNumber:
+			if creating && empty && !column.SerialOnCommit {
+				enter(column, column.Table.reserveSerial(column))
+			}
+		case FmpAutoEnterCreateDate, FmpAutoEnterCreateTime, FmpAutoEnterCreateTS:
+			if creating && empty {
+				enter(column, autoEnterStamp(column.AutoEnter, now))
+			}
+		case FmpAutoEnterCreateName, FmpAutoEnterCreateAccountName:
+			if creating && empty {
+				enter(column, ctx.autoEnterName(column.AutoEnter))
+			}
+		case FmpAutoEnterModDate, FmpAutoEnterModTime, FmpAutoEnterModTS:
+			enter(column, autoEnterStamp(column.AutoEnter, now))
+		case FmpAutoEnterModName, FmpAutoEnterModAccountName:
+			enter(column, ctx.autoEnterName(column.AutoEnter))
+		case FmpAutoEnterCalculation, FmpAutoEnterCalculationReplacingExistingValue:
+			if column.Calculation == nil {
+				continue
+			}
+			node, err := column.Calculation.AST()
+			if err != nil {
+				continue
+			}
+			if !creating && !refersTo(node, changed) {
+				continue
+			}
+			if !empty && (creating || column.AutoEnter == FmpAutoEnterCalculation) {
+				continue
+			}
+			if !column.EvaluateIfEmpty && referencesEmpty(node, record) {
+				continue
+			}
+			value, err := evalCalcNode(node, &FmpEvalContext{Record: record, Now: ctx.now, AccountName: ctx.accountName})
+			if errors.Is(err, ErrUnsupported) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			enter(column, value)
+		}
+	}
+	return set, nil
+}
+
+// applySerialsOnCommit enters the serial numbers that are generated when a
+// new record is committed rather than created.
+func (tx *FmpTransaction) applySerialsOnCommit() {
+	if tx.file.noAutoEnter {
+		return
+	}
+	for _, record := range tx.records {
+		for _, column := range record.Table.Columns {
+			if column.autoEnters && column.AutoEnter == FmpAutoEnterSerialNumber && column.SerialOnCommit && record.Values[column.Index] == "" {
+				record.Values[column.Index] = record.Table.nextSerialLocked(column)
+				tx.file.setValue([]uint64{record.Table.ID, 5, record.Index, column.Index}, encodeString(record.Values[column.Index]))
+			}
+		}
+	}
+}
+
+func autoEnterStamp(option FmpAutoEnterOption, now time.Time) string {
+	switch option {
+	case FmpAutoEnterCreateDate, FmpAutoEnterModDate:
+		return now.Format(FmpDateLayout)
+	case FmpAutoEnterCreateTime, FmpAutoEnterModTime:
+		return now.Format(FmpTimeLayout)
+	}
+	return now.Format(FmpDateTimeLayout)
+}
+
+func (ctx *FmpFile) autoEnterName(option FmpAutoEnterOption) string {
+	if option == FmpAutoEnterCreateName || option == FmpAutoEnterModName {
+		return ctx.userName
+	}
+	return ctx.accountName
+}
+
+// refersTo reports whether a calculation refers to any of the given fields.
+func refersTo(node FmpCalcNode, fields map[uint64]bool) bool {
+	found := false
+	walkCalc(node, func(n FmpCalcNode) {
+		if field, ok := n.(*FmpCalcField); ok && field.Ref.Column != nil && fields[field.Ref.Column.Index] {
+			found = true
+		}
+	})
+	return found
+}
+
+// referencesEmpty reports whether a calculation refers to fields of the
+// record, and all of them are empty. Global fields count with their global
+// value.
+func referencesEmpty(node FmpCalcNode, record *FmpRecord) bool {
+	refs, empty := 0, true
+	walkCalc(node, func(n FmpCalcNode) {
+		if field, ok := n.(*FmpCalcField); ok && field.Ref.Column != nil && field.Ref.Column.Table == record.Table {
+			column := field.Ref.Column
+			value := record.Values[column.Index]
+			if column.StorageType == FmpFieldStorageGlobal {
+				value = record.Table.Global(column.Name)
+			}
+			refs++
+			empty = empty && value == ""
+		}
+	})
+	return refs > 0 && empty
+}
+
+func walkCalc(node FmpCalcNode, visit func(FmpCalcNode)) {
+	visit(node)
+	switch n := node.(type) {
+	case *FmpCalcUnary:
+		walkCalc(n.Operand, visit)
+	case *FmpCalcBinary:
+		walkCalc(n.Left, visit)
+		walkCalc(n.Right, visit)
+	case *FmpCalcParen:
+		walkCalc(n.Inner, visit)
+	case *FmpCalcCall:
+		for _, arg := range n.Args {
+			walkCalc(arg, visit)
+		}
+	case *FmpCalcLet:
+		for _, value := range n.Values {
+			walkCalc(value, visit)
+		}
+		walkCalc(n.Body, visit)
+	}
+}
+
+// readSerial reads the next serial number of a field from key 9 of its field
+// definition: the next value as text at key 1, and the length-prefixed
+// increment at key 2. The sample file has no serial number fields, so this
+// layout is this package's own. Fields without a stored next value continue
+// from the serial of the last record that has one.
+func (column *FmpColumn) readSerial(d *FmpDict) {
+	column.nextSerial = decodeString(d.GetValue(9, 1))
+	column.serialIncrement = 1
+	if increment, _, ok := decodeLengthPrefixed(d.GetValue(9, 2), 0); ok && increment > 0 {
+		column.serialIncrement = increment
+	}
+}
+
+// reserveSerial hands out the next serial number of a field, so that it is
+// not handed out again even if the transaction is rolled back.
+func (t *FmpTable) reserveSerial(column *FmpColumn) string {
+	t.file.mu.Lock()
+	defer t.file.mu.Unlock()
+	return t.nextSerialLocked(column)
+}
+
+// nextSerialLocked hands out the next serial number of a field and stores the
+// one after it in the field definition.
+func (t *FmpTable) nextSerialLocked(column *FmpColumn) string {
+	serial := column.nextSerial
+	increment := max(column.serialIncrement, 1)
+	if serial == "" {
+		serial = "1"
+		last := uint64(0)
+		for _, record := range t.Records {
+			if value := record.Values[column.Index]; value != "" && record.Index > last {
+				serial, last = incrementSerial(value, increment), record.Index
+			}
+		}
+	}
+	column.nextSerial = incrementSerial(serial, increment)
+	t.file.setValue([]uint64{t.ID, 3, 5, column.Index, 9, 1}, encodeString(column.nextSerial))
+	return serial
+}
+
+// incrementSerial increments the last number in a serial like FileMaker does,
+// keeping any text around it and padding it with zeros to the same length, so
+// that INV0009 is followed by INV0010.
+func incrementSerial(serial string, increment uint64) string {
+	end := len(serial)
+	for end > 0 && (serial[end-1] < '0' || serial[end-1] > '9') {
+		end--
+	}
+	start := end
+	for start > 0 && serial[start-1] >= '0' && serial[start-1] <= '9' {
+		start--
+	}
+	if start == end {
+		return serial + strconv.FormatUint(increment, 10)
+	}
+
+	n, _ := strconv.ParseUint(serial[start:end], 10, 64)
+	digits := strconv.FormatUint(n+increment, 10)
+	for len(digits) < end-start {
+		digits = "0" + digits
+	}
+	return serial[:start] + digits + serial[end:]
+}
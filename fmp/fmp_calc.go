@@ -0,0 +1,412 @@
+package fmp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// FmpCalculation is a calculation as stored in the file, such as the formula
+// of a calculation field or the value of a Set Variable script step.
+//
+// Calculations are stored as the tokens of their source text, in the order
+// they were written, including the whitespace between them. The tokens are:
+//
+//   - 0x04 and 0x05: opening and closing parenthesis.
+//   - 0x06: the ; separating arguments, and 0x07 and 0x08 the brackets around
+//     the variables of Let.
+//   - 0x0c: whitespace, holding the text in 0x13 chunks like a text literal,
+//     and ending with 0x00.
+//   - 0x10: number, followed by 7 reserved bytes, a length byte, and the
+//     digits as text, so that the number starts at the 9th byte after the
+//     token.
+//   - 0x13: text literal, followed by a length byte and the text. Longer text
+//     continues in further 0x13 chunks.
+//   - 0x16: field, followed by the length-prefixed IDs of the table occurrence
+//     and the field. Occurrence 0 is a field of the context table referred to
+//     by its name only.
+//   - 0x1a: variable, followed by a length byte and the name. Variables of Let
+//     have no $ prefix.
+//   - 0x25-0x33 and 0x50: operators, see calcOperatorMap.
+//   - 0x9a: function, followed by a length byte and its name.
+//   - 0x9b: the Get function. The name of the information to get follows as
+//     0x9c and a byte identifying it, see calcGetNames.
+//
+// Only the operators, numbers, variables and Get were seen in files; the
+// other tokens are this package's own, as where FileMaker keeps them is not
+// known. The space FileMaker shows between a function name and its
+// parenthesis is not stored. Key 6 next to a calculation holds it in a compact form without
+// whitespace, for example 9b 65 for Get ( UUID ), which is not used here.
+// Bytecode using other tokens is kept as is, and shown in hexadecimal.
+type FmpCalculation struct {
+	Bytecode []byte
+
+	file  *FmpFile
+	table *FmpTable // Context of bare field names, if any
+}
+
+const (
+	calcOpOpen      = 0x04
+	calcOpClose     = 0x05
+	calcOpSeparator = 0x06
+	calcOpListOpen  = 0x07
+	calcOpListClose = 0x08
+	calcOpSpace     = 0x0c
+	calcOpNumber    = 0x10
+	calcOpText      = 0x13
+	calcOpField     = 0x16
+	calcOpVariable  = 0x1a
+	calcOpFunction  = 0x9a
+	calcOpGet       = 0x9b
+	calcOpGetName   = 0x9c
+
+	calcNumberHeaderSize = 9
+)
+
+// calcGetNames maps the bytes following 0x9c to the information Get returns.
+var calcGetNames = map[byte]string{
+	0x65: "UUID",
+}
+
+// FmpCalcNode is a node of a decoded calculation. Its String method prints it
+// in FileMaker calculation syntax.
+type FmpCalcNode interface {
+	String() string
+	precedence() int
+}
+
+type FmpCalcNumber struct {
+	Value string
+}
+
+type FmpCalcText struct {
+	Value string
+}
+
+// FmpCalcField refers to a field. Fields of the context table can be referred
+// to by their name only, in which case Ref has no occurrence.
+type FmpCalcField struct {
+	Ref FmpFieldRef
+}
+
+type FmpCalcVariable struct {
+	Name string // Including the $ or $$ prefix, if any
+}
+
+type FmpCalcGet struct {
+	Name string
+}
+
+type FmpCalcUnary struct {
+	Op      FmpCalculationOperator // FmpCalcOperatorSubtract or FmpCalcOperatorNot
+	Operand FmpCalcNode
+}
+
+type FmpCalcBinary struct {
+	Op    FmpCalculationOperator
+	Left  FmpCalcNode
+	Right FmpCalcNode
+}
+
+// FmpCalcParen is an explicitly parenthesized expression.
+type FmpCalcParen struct {
+	Inner FmpCalcNode
+}
+
+type FmpCalcCall struct {
+	Name string
+	Args []FmpCalcNode
+}
+
+type FmpCalcLet struct {
+	Names  []string
+	Values []FmpCalcNode
+	Body   FmpCalcNode
+}
+
+// FmpCalcRaw holds bytecode that could not be decoded.
+type FmpCalcRaw struct {
+	Bytes []byte
+}
+
+func (ctx *FmpFile) decodeCalculation(value []byte, table *FmpTable) *FmpCalculation {
+	if len(value) == 0 {
+		return nil
+	}
+	return &FmpCalculation{Bytecode: value, file: ctx, table: table}
+}
+
+// AST decodes the bytecode into a tree of nodes. Field references are
+// resolved against the file the calculation was read from, and names of
+// fields without an occurrence against the table of the field it belongs to.
+func (c *FmpCalculation) AST() (FmpCalcNode, error) {
+	if c.file != nil {
+		c.file.mu.RLock()
+		defer c.file.mu.RUnlock()
+	}
+
+	tokens, err := decodeCalcTokens(c.file, c.table, c.Bytecode)
+	if errors.Is(err, ErrUnsupported) {
+		return &FmpCalcRaw{Bytes: c.Bytecode}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseCalcTokens(c.file, c.table, tokens)
+}
+
+// String renders the calculation in FileMaker calculation syntax, as it was
+// written. Bytecode that cannot be decoded is shown in hexadecimal.
+func (c *FmpCalculation) String() string {
+	if c == nil {
+		return ""
+	}
+	if c.file != nil {
+		c.file.mu.RLock()
+		defer c.file.mu.RUnlock()
+	}
+
+	tokens, err := decodeCalcTokens(c.file, c.table, c.Bytecode)
+	if err != nil {
+		return (&FmpCalcRaw{Bytes: c.Bytecode}).String()
+	}
+
+	var b strings.Builder
+	for _, tok := range tokens {
+		b.WriteString(tok.space)
+		b.WriteString(tok.source())
+	}
+	return b.String()
+}
+
+// decodeCalcTokens splits bytecode into the tokens of its source text. Fields
+// are resolved against the file, which must be locked for reading, and
+// fields without an occurrence against the context table. It returns
+// ErrUnsupported for tokens it does not know, and ErrBadCalculation for
+// truncated ones.
+func decodeCalcTokens(file *FmpFile, table *FmpTable, code []byte) ([]calcToken, error) {
+	tokens := make([]calcToken, 0)
+	pos := 0
+	var space strings.Builder
+
+	take := func(n int) ([]byte, bool) {
+		if n < 0 || pos+n > len(code) {
+			return nil, false
+		}
+		b := code[pos : pos+n]
+		pos += n
+		return b, true
+	}
+	takeText := func() ([]byte, bool) {
+		n, ok := take(1)
+		if !ok {
+			return nil, false
+		}
+		return take(int(n[0]))
+	}
+
+	function := false
+	for pos < len(code) {
+		op := code[pos]
+		pos++
+		tok := calcToken{kind: calcTokenPunct, offset: pos - 1}
+		ok := true
+
+		switch op {
+		case calcOpOpen:
+			tok.text = "("
+			if function && space.Len() == 0 {
+				space.WriteString(" ")
+			}
+
+		case calcOpClose:
+			tok.text = ")"
+
+		case calcOpSeparator:
+			tok.text = ";"
+
+		case calcOpListOpen:
+			tok.text = "["
+
+		case calcOpListClose:
+			tok.text = "]"
+
+		case calcOpSpace:
+			for ok && pos < len(code) && code[pos] == calcOpText {
+				var text []byte
+				pos++
+				if text, ok = takeText(); ok {
+					space.WriteString(decodeString(text))
+				}
+			}
+			if _, ok = take(1); ok && code[pos-1] != 0 {
+				return nil, &FmpUnsupportedError{Name: fmt.Sprintf("calculation token %02x", code[pos-1])}
+			}
+			if !ok {
+				return nil, ErrBadCalculation
+			}
+			continue
+
+		case calcOpNumber:
+			var header, digits []byte
+			header, ok = take(calcNumberHeaderSize - 1)
+			if ok {
+				digits, ok = take(int(header[len(header)-1]))
+			}
+			tok.kind, tok.text = calcTokenNumber, string(digits)
+
+		case calcOpText:
+			var text []byte
+			pos--
+			for ok && pos < len(code) && code[pos] == calcOpText {
+				var chunk []byte
+				pos++
+				if chunk, ok = takeText(); ok {
+					text = append(text, chunk...)
+				}
+			}
+			tok.kind, tok.text = calcTokenText, decodeString(text)
+
+		case calcOpField:
+			var occID, fieldID uint64
+			occID, pos, ok = decodeLengthPrefixed(code, pos)
+			if ok {
+				fieldID, pos, ok = decodeLengthPrefixed(code, pos)
+			}
+			ref := FmpFieldRef{}
+			switch {
+			case occID == 0 && table != nil:
+				ref.Column = table.Columns[fieldID]
+			case occID != 0 && file != nil:
+				ref = file.resolveFieldRef(occID, fieldID)
+			}
+			tok.kind, tok.ref = calcTokenField, &ref
+			if occID == 0 {
+				tok.kind = calcTokenName
+			}
+
+		case calcOpVariable:
+			var name []byte
+			name, ok = takeText()
+			tok.kind, tok.text = calcTokenName, string(name)
+
+		case calcOpFunction:
+			var name []byte
+			name, ok = takeText()
+			tok.kind, tok.text = calcTokenName, string(name)
+
+		case calcOpGet:
+			tok.kind, tok.text = calcTokenName, "Get"
+
+		case calcOpGetName:
+			var id []byte
+			if id, ok = take(1); ok {
+				name, known := calcGetNames[id[0]]
+				if !known {
+					return nil, &FmpUnsupportedError{Name: fmt.Sprintf("Get information %02x", id[0])}
+				}
+				tok.kind, tok.text = calcTokenName, name
+			}
+
+		default:
+			operator, known := calcOperatorMap[op]
+			if !known {
+				return nil, &FmpUnsupportedError{Name: fmt.Sprintf("calculation token %02x", op)}
+			}
+			tok.text = operator.String()
+		}
+
+		if !ok {
+			return nil, ErrBadCalculation
+		}
+		function = op == calcOpFunction || op == calcOpGet
+		tok.space = space.String()
+		space.Reset()
+		tokens = append(tokens, tok)
+	}
+
+	return append(tokens, calcToken{kind: calcTokenEOF, offset: len(code), space: space.String()}), nil
+}
+
+func (ctx *FmpFile) resolveFieldRef(occID, fieldID uint64) FmpFieldRef {
+	ref := FmpFieldRef{Occurrence: ctx.occurrenceByID(occID)}
+	if ref.Occurrence != nil && ref.Occurrence.Table != nil {
+		ref.Column = ref.Occurrence.Table.Columns[fieldID]
+	}
+	return ref
+}
+
+const atomPrecedence = 10
+
+func (n *FmpCalcNumber) String() string    { return n.Value }
+func (n *FmpCalcNumber) precedence() int   { return atomPrecedence }
+func (n *FmpCalcText) precedence() int     { return atomPrecedence }
+func (n *FmpCalcField) precedence() int    { return atomPrecedence }
+func (n *FmpCalcVariable) String() string  { return n.Name }
+func (n *FmpCalcVariable) precedence() int { return atomPrecedence }
+func (n *FmpCalcGet) String() string       { return "Get ( " + n.Name + " )" }
+func (n *FmpCalcGet) precedence() int      { return atomPrecedence }
+func (n *FmpCalcUnary) precedence() int    { return n.Op.precedence(true) }
+func (n *FmpCalcBinary) precedence() int   { return n.Op.precedence(false) }
+func (n *FmpCalcParen) String() string     { return "(" + n.Inner.String() + ")" }
+func (n *FmpCalcParen) precedence() int    { return atomPrecedence }
+func (n *FmpCalcCall) precedence() int     { return atomPrecedence }
+func (n *FmpCalcLet) precedence() int      { return atomPrecedence }
+func (n *FmpCalcRaw) String() string       { return fmt.Sprintf("‹%x›", n.Bytes) }
+func (n *FmpCalcRaw) precedence() int      { return atomPrecedence }
+
+func (n *FmpCalcText) String() string {
+	s := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "¶", `\¶`, "\r\n", "¶", "\r", "¶", "\n", "¶").Replace(n.Value)
+	return `"` + s + `"`
+}
+
+func (n *FmpCalcField) String() string {
+	if n.Ref.Occurrence == nil && n.Ref.Column != nil {
+		return n.Ref.Column.Name
+	}
+	return n.Ref.String()
+}
+
+func (n *FmpCalcUnary) String() string {
+	operand := parenthesize(n.Operand, n.precedence(), false)
+	if n.Op == FmpCalcOperatorNot {
+		return "not " + operand
+	}
+	return "-" + operand
+}
+
+func (n *FmpCalcBinary) String() string {
+	return parenthesize(n.Left, n.precedence(), false) + " " + n.Op.String() + " " + parenthesize(n.Right, n.precedence(), true)
+}
+
+func (n *FmpCalcCall) String() string {
+	if len(n.Args) == 0 {
+		return n.Name
+	}
+	args := make([]string, len(n.Args))
+	for i, arg := range n.Args {
+		args[i] = arg.String()
+	}
+	return n.Name + " ( " + strings.Join(args, " ; ") + " )"
+}
+
+func (n *FmpCalcLet) String() string {
+	vars := make([]string, len(n.Names))
+	for i, name := range n.Names {
+		vars[i] = name + " = " + n.Values[i].String()
+	}
+	if len(vars) == 1 {
+		return "Let ( " + vars[0] + " ; " + n.Body.String() + " )"
+	}
+	return "Let ( [ " + strings.Join(vars, " ; ") + " ] ; " + n.Body.String() + " )"
+}
+
+// parenthesize prints a node, wrapping it in parentheses if it binds less
+// tightly than its parent. Operators are left-associative, so the right-hand
+// side of a binary operator also needs them when it binds equally tightly.
+func parenthesize(n FmpCalcNode, parent int, right bool) string {
+	if n.precedence() < parent || (right && n.precedence() == parent) {
+		return "(" + n.String() + ")"
+	}
+	return n.String()
+}
@@ -0,0 +1,534 @@
+package fmp
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FmpEvalContext supplies what a calculation needs besides its formula.
+type FmpEvalContext struct {
+	Record      *FmpRecord             // Record whose fields are referenced
+	Variables   map[string]string      // Values of $ and $$ variables
+	Now         func() time.Time       // Clock for Get ( Current... ), defaults to time.Now
+	AccountName string                 // Result of Get ( AccountName )
+	UUID        func() (string, error) // Generator for Get ( UUID ), defaults to a random UUID
+}
+
+// FmpUnsupportedError is returned when a calculation uses a function, or
+// bytecode, that the evaluator does not implement.
+type FmpUnsupportedError struct {
+	Name string
+}
+
+func (e *FmpUnsupportedError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrUnsupported, e.Name)
+}
+
+func (e *FmpUnsupportedError) Unwrap() error {
+	return ErrUnsupported
+}
+
+// Eval evaluates the calculation and returns its result as text, formatted
+// the way field values are stored. It fails with an FmpUnsupportedError
+// rather than guessing when the calculation uses something unsupported.
+func (c *FmpCalculation) Eval(ctx *FmpEvalContext) (string, error) {
+	node, err := c.AST()
+	if err != nil {
+		return "", err
+	}
+	return evalCalcNode(node, ctx)
+}
+
+func evalCalcNode(node FmpCalcNode, ctx *FmpEvalContext) (string, error) {
+	if ctx == nil {
+		ctx = &FmpEvalContext{}
+	}
+
+	e := &calcEvaluator{ctx: ctx}
+	v, err := e.eval(node)
+	if err != nil {
+		return "", err
+	}
+	return v.text(), nil
+}
+
+// Evaluate evaluates the calculation of the named field for this record,
+// such as an unstored calculation or an auto-enter calculation.
+func (r *FmpRecord) Evaluate(name string, ctx *FmpEvalContext) (string, error) {
+	column := r.Table.Column(name)
+	if column == nil {
+		return "", ErrUnknownColumn
+	}
+	if column.Calculation == nil {
+		return "", &FmpUnsupportedError{Name: "field " + name + " has no calculation"}
+	}
+
+	evalCtx := FmpEvalContext{}
+	if ctx != nil {
+		evalCtx = *ctx
+	}
+	evalCtx.Record = r
+	return column.Calculation.Eval(&evalCtx)
+}
+
+// calcValue is an intermediate result. Like in FileMaker, values are
+// converted between types as operators and functions require.
+type calcValue struct {
+	kind FmpDataType
+	str  string
+	num  float64
+	time time.Time
+}
+
+func textValue(s string) calcValue    { return calcValue{kind: FmpDataText, str: s} }
+func numberValue(n float64) calcValue { return calcValue{kind: FmpDataNumber, num: n} }
+func timeValue(kind FmpDataType, t time.Time) calcValue {
+	return calcValue{kind: kind, time: t}
+}
+
+func boolValue(b bool) calcValue {
+	if b {
+		return numberValue(1)
+	}
+	return numberValue(0)
+}
+
+func (v calcValue) text() string {
+	switch v.kind {
+	case FmpDataNumber:
+		if math.IsNaN(v.num) || math.IsInf(v.num, 0) {
+			return "?"
+		}
+		return strconv.FormatFloat(v.num, 'f', -1, 64)
+	case FmpDataDate, FmpDataTime, FmpDataTS:
+		return v.time.Format(dataTypeLayouts[v.kind])
+	}
+	return v.str
+}
+
+func (v calcValue) number() float64 {
+	switch v.kind {
+	case FmpDataNumber:
+		return v.num
+	case FmpDataDate:
+		return float64(v.time.Sub(fmpEpoch)/(24*time.Hour)) + 1
+	case FmpDataTime:
+		return float64(v.time.Hour()*3600 + v.time.Minute()*60 + v.time.Second())
+	case FmpDataTS:
+		return float64(v.time.Sub(fmpEpoch) / time.Second)
+	}
+	return parseCalcNumber(v.str)
+}
+
+func (v calcValue) bool() bool {
+	return v.number() != 0
+}
+
+// fmpEpoch is where FileMaker counts dates (from 1) and timestamps (from 0)
+// from.
+var fmpEpoch = time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// parseCalcNumber extracts a number from text the way GetAsNumber does,
+// ignoring everything but digits, the decimal point and a leading minus.
+func parseCalcNumber(s string) float64 {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9', r == '.' && !strings.Contains(b.String(), "."):
+			b.WriteRune(r)
+		case r == '-' && b.Len() == 0:
+			b.WriteRune(r)
+		}
+	}
+	n, _ := strconv.ParseFloat(b.String(), 64)
+	return n
+}
+
+func fieldValue(value string, dataType FmpDataType) calcValue {
+	switch dataType {
+	case FmpDataNumber:
+		return numberValue(parseCalcNumber(value))
+	case FmpDataDate, FmpDataTime, FmpDataTS:
+		if t, err := time.Parse(dataTypeLayouts[dataType], value); err == nil {
+			return timeValue(dataType, t)
+		}
+	}
+	return textValue(value)
+}
+
+type calcEvaluator struct {
+	ctx    *FmpEvalContext
+	scopes []map[string]calcValue
+}
+
+func (e *calcEvaluator) eval(node FmpCalcNode) (calcValue, error) {
+	switch n := node.(type) {
+	case *FmpCalcNumber:
+		return numberValue(parseCalcNumber(n.Value)), nil
+
+	case *FmpCalcText:
+		return textValue(n.Value), nil
+
+	case *FmpCalcParen:
+		return e.eval(n.Inner)
+
+	case *FmpCalcVariable:
+		for i := len(e.scopes) - 1; i >= 0; i-- {
+			if v, ok := e.scopes[i][n.Name]; ok {
+				return v, nil
+			}
+		}
+		return textValue(e.ctx.Variables[n.Name]), nil
+
+	case *FmpCalcField:
+		return e.field(n)
+
+	case *FmpCalcGet:
+		return e.get(n.Name)
+
+	case *FmpCalcUnary:
+		v, err := e.eval(n.Operand)
+		if err != nil {
+			return calcValue{}, err
+		}
+		if n.Op == FmpCalcOperatorNot {
+			return boolValue(!v.bool()), nil
+		}
+		return numberValue(-v.number()), nil
+
+	case *FmpCalcBinary:
+		left, err := e.eval(n.Left)
+		if err != nil {
+			return calcValue{}, err
+		}
+		right, err := e.eval(n.Right)
+		if err != nil {
+			return calcValue{}, err
+		}
+		return binaryOp(n.Op, left, right), nil
+
+	case *FmpCalcLet:
+		scope := map[string]calcValue{}
+		e.scopes = append(e.scopes, scope)
+		defer func() { e.scopes = e.scopes[:len(e.scopes)-1] }()
+
+		for i, name := range n.Names {
+			v, err := e.eval(n.Values[i])
+			if err != nil {
+				return calcValue{}, err
+			}
+			scope[name] = v
+		}
+		return e.eval(n.Body)
+
+	case *FmpCalcCall:
+		return e.call(n)
+	}
+
+	return calcValue{}, &FmpUnsupportedError{Name: node.String()}
+}
+
+func (e *calcEvaluator) field(n *FmpCalcField) (calcValue, error) {
+	column := n.Ref.Column
+	if column != nil && column.StorageType == FmpFieldStorageGlobal {
+		return fieldValue(column.Table.Global(column.Name), column.DataType), nil
+	}
+
+	record := e.ctx.Record
+	if record == nil || column == nil {
+		return calcValue{}, &FmpUnsupportedError{Name: n.String()}
+	}
+	if column.Table != record.Table {
+		// Fields of other tables come from the first related record.
+		record = nil
+		if e.ctx.Record != nil && n.Ref.Occurrence != nil {
+			for related := range e.ctx.Record.Related(n.Ref.Occurrence.Name) {
+				record = related
+				break
+			}
+		}
+		if record == nil {
+			return textValue(""), nil
+		}
+	}
+
+	column.Table.file.mu.RLock()
+	value := record.Values[column.Index]
+	column.Table.file.mu.RUnlock()
+	return fieldValue(value, column.DataType), nil
+}
+
+func (e *calcEvaluator) now() time.Time {
+	if e.ctx.Now != nil {
+		return e.ctx.Now()
+	}
+	return time.Now()
+}
+
+func (e *calcEvaluator) get(name string) (calcValue, error) {
+	now := e.now()
+	switch strings.ToLower(name) {
+	case "currentdate":
+		return timeValue(FmpDataDate, time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)), nil
+	case "currenttime":
+		return timeValue(FmpDataTime, time.Date(0, 1, 1, now.Hour(), now.Minute(), now.Second(), 0, time.UTC)), nil
+	case "currenttimestamp":
+		return timeValue(FmpDataTS, time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second(), 0, time.UTC)), nil
+	case "accountname":
+		return textValue(e.ctx.AccountName), nil
+	case "uuid":
+		if e.ctx.UUID != nil {
+			uuid, err := e.ctx.UUID()
+			return textValue(uuid), err
+		}
+		uuid, err := newUUID()
+		return textValue(uuid), err
+	}
+	return calcValue{}, &FmpUnsupportedError{Name: "Get ( " + name + " )"}
+}
+
+func binaryOp(op FmpCalculationOperator, left, right calcValue) calcValue {
+	switch op {
+	case FmpCalcOperatorConcatenate:
+		return textValue(left.text() + right.text())
+
+	case FmpCalcOperatorAdd, FmpCalcOperatorSubtract:
+		// Adding to a date counts days, and to a time or timestamp seconds.
+		if left.kind == FmpDataDate || left.kind == FmpDataTime || left.kind == FmpDataTS {
+			if right.kind == left.kind && op == FmpCalcOperatorSubtract {
+				return numberValue(left.number() - right.number())
+			}
+			unit := time.Second
+			if left.kind == FmpDataDate {
+				unit = 24 * time.Hour
+			}
+			delta := time.Duration(right.number() * float64(unit))
+			if op == FmpCalcOperatorSubtract {
+				delta = -delta
+			}
+			return timeValue(left.kind, left.time.Add(delta))
+		}
+		if op == FmpCalcOperatorSubtract {
+			return numberValue(left.number() - right.number())
+		}
+		return numberValue(left.number() + right.number())
+
+	case FmpCalcOperatorMultiply:
+		return numberValue(left.number() * right.number())
+	case FmpCalcOperatorDivide:
+		if right.number() == 0 {
+			return numberValue(math.NaN())
+		}
+		return numberValue(left.number() / right.number())
+	case FmpCalcOperatorPower:
+		return numberValue(math.Pow(left.number(), right.number()))
+
+	case FmpCalcOperatorAnd:
+		return boolValue(left.bool() && right.bool())
+	case FmpCalcOperatorOr:
+		return boolValue(left.bool() || right.bool())
+	case FmpCalcOperatorXor:
+		return boolValue(left.bool() != right.bool())
+	}
+
+	c := compareCalcValues(left, right)
+	switch op {
+	case FmpCalcOperatorEqual:
+		return boolValue(c == 0)
+	case FmpCalcOperatorNotEqual:
+		return boolValue(c != 0)
+	case FmpCalcOperatorLess:
+		return boolValue(c < 0)
+	case FmpCalcOperatorLessEqual:
+		return boolValue(c <= 0)
+	case FmpCalcOperatorGreater:
+		return boolValue(c > 0)
+	case FmpCalcOperatorGreaterEqual:
+		return boolValue(c >= 0)
+	}
+	return textValue("?")
+}
+
+// compareCalcValues compares text case-insensitively, and anything else by
+// its numeric value.
+func compareCalcValues(a, b calcValue) int {
+	if a.kind == FmpDataText && b.kind == FmpDataText {
+		return strings.Compare(strings.ToLower(a.str), strings.ToLower(b.str))
+	}
+	switch an, bn := a.number(), b.number(); {
+	case an < bn:
+		return -1
+	case an > bn:
+		return 1
+	}
+	return 0
+}
+
+func (e *calcEvaluator) call(n *FmpCalcCall) (calcValue, error) {
+	name := strings.ToLower(n.Name)
+
+	// Functions that only evaluate some of their arguments.
+	switch name {
+	case "if":
+		if len(n.Args) < 2 || len(n.Args) > 3 {
+			return calcValue{}, ErrBadCalculation
+		}
+		test, err := e.eval(n.Args[0])
+		if err != nil {
+			return calcValue{}, err
+		}
+		if test.bool() {
+			return e.eval(n.Args[1])
+		}
+		if len(n.Args) == 3 {
+			return e.eval(n.Args[2])
+		}
+		return textValue(""), nil
+
+	case "case":
+		for i := 0; i+1 < len(n.Args); i += 2 {
+			test, err := e.eval(n.Args[i])
+			if err != nil {
+				return calcValue{}, err
+			}
+			if test.bool() {
+				return e.eval(n.Args[i+1])
+			}
+		}
+		if len(n.Args)%2 == 1 {
+			return e.eval(n.Args[len(n.Args)-1])
+		}
+		return textValue(""), nil
+	}
+
+	args := make([]calcValue, len(n.Args))
+	for i, arg := range n.Args {
+		v, err := e.eval(arg)
+		if err != nil {
+			return calcValue{}, err
+		}
+		args[i] = v
+	}
+	arity := func(count int) error {
+		if len(args) != count {
+			return ErrBadCalculation
+		}
+		return nil
+	}
+
+	switch name {
+	case "left", "right":
+		if err := arity(2); err != nil {
+			return calcValue{}, err
+		}
+		runes := []rune(args[0].text())
+		count := min(max(int(args[1].number()), 0), len(runes))
+		if name == "left" {
+			return textValue(string(runes[:count])), nil
+		}
+		return textValue(string(runes[len(runes)-count:])), nil
+
+	case "middle":
+		if err := arity(3); err != nil {
+			return calcValue{}, err
+		}
+		runes := []rune(args[0].text())
+		start := min(max(int(args[1].number())-1, 0), len(runes))
+		end := min(start+max(int(args[2].number()), 0), len(runes))
+		return textValue(string(runes[start:end])), nil
+
+	case "length":
+		if err := arity(1); err != nil {
+			return calcValue{}, err
+		}
+		return numberValue(float64(utf8.RuneCountInString(args[0].text()))), nil
+
+	case "upper", "lower", "trim", "isempty":
+		if err := arity(1); err != nil {
+			return calcValue{}, err
+		}
+		s := args[0].text()
+		switch name {
+		case "upper":
+			return textValue(strings.ToUpper(s)), nil
+		case "lower":
+			return textValue(strings.ToLower(s)), nil
+		case "trim":
+			return textValue(strings.Trim(s, " ")), nil
+		}
+		return boolValue(s == ""), nil
+
+	case "date":
+		if err := arity(3); err != nil {
+			return calcValue{}, err
+		}
+		month, day, year := int(args[0].number()), int(args[1].number()), int(args[2].number())
+		return timeValue(FmpDataDate, time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)), nil
+
+	case "year", "month", "day":
+		if err := arity(1); err != nil {
+			return calcValue{}, err
+		}
+		t := args[0].time
+		if args[0].kind != FmpDataDate && args[0].kind != FmpDataTS {
+			parsed, err := time.Parse(FmpDateLayout, args[0].text())
+			if err != nil {
+				return textValue("?"), nil
+			}
+			t = parsed
+		}
+		switch name {
+		case "year":
+			return numberValue(float64(t.Year())), nil
+		case "month":
+			return numberValue(float64(t.Month())), nil
+		}
+		return numberValue(float64(t.Day())), nil
+
+	case "abs", "int":
+		if err := arity(1); err != nil {
+			return calcValue{}, err
+		}
+		if name == "abs" {
+			return numberValue(math.Abs(args[0].number())), nil
+		}
+		return numberValue(math.Trunc(args[0].number())), nil
+
+	case "round":
+		if err := arity(2); err != nil {
+			return calcValue{}, err
+		}
+		scale := math.Pow(10, args[1].number())
+		return numberValue(math.Round(args[0].number()*scale) / scale), nil
+
+	case "getastext":
+		if err := arity(1); err != nil {
+			return calcValue{}, err
+		}
+		return textValue(args[0].text()), nil
+
+	case "getasnumber":
+		if err := arity(1); err != nil {
+			return calcValue{}, err
+		}
+		return numberValue(args[0].number()), nil
+	}
+
+	return calcValue{}, &FmpUnsupportedError{Name: n.Name}
+}
+
+// newUUID returns a random version 4 UUID in upper case, like Get ( UUID ).
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%X-%X-%X-%X-%X", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,608 @@
+package fmp
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FmpCalcSyntaxError is returned when calculation source text cannot be
+// parsed. Offset is the position in bytes of the offending token.
+type FmpCalcSyntaxError struct {
+	Offset  int
+	Message string
+}
+
+func (e *FmpCalcSyntaxError) Error() string {
+	return fmt.Sprintf("%v at offset %d: %s", ErrBadCalculation, e.Offset, e.Message)
+}
+
+func (e *FmpCalcSyntaxError) Unwrap() error {
+	return ErrBadCalculation
+}
+
+type calcTokenKind int
+
+const (
+	calcTokenEOF calcTokenKind = iota
+	calcTokenNumber
+	calcTokenText
+	calcTokenName
+	calcTokenField
+	calcTokenPunct
+)
+
+type calcToken struct {
+	kind   calcTokenKind
+	offset int
+	text   string // Literal value, name, or punctuation
+	field  string // Field name of a Table::Field reference
+	space  string // Whitespace before the token
+
+	// Set by parsing, or by decoding for fields.
+	ref      *FmpFieldRef // Field the token refers to
+	variable bool         // Name of a variable declared by Let
+}
+
+// source returns the token as it is written in source text.
+func (tok calcToken) source() string {
+	switch {
+	case tok.ref != nil:
+		return (&FmpCalcField{Ref: *tok.ref}).String()
+	case tok.kind == calcTokenText:
+		return (&FmpCalcText{Value: tok.text}).String()
+	case tok.kind == calcTokenField:
+		return tok.text + "::" + tok.field
+	}
+	return tok.text
+}
+
+// calcOperatorNames maps the spellings of operators in source text to
+// operators. Comparisons can also be written with ASCII characters.
+var calcOperatorNames = map[string]FmpCalculationOperator{
+	"+": FmpCalcOperatorAdd, "-": FmpCalcOperatorSubtract, "*": FmpCalcOperatorMultiply,
+	"/": FmpCalcOperatorDivide, "^": FmpCalcOperatorPower, "&": FmpCalcOperatorConcatenate,
+	"=": FmpCalcOperatorEqual, "≠": FmpCalcOperatorNotEqual, "<>": FmpCalcOperatorNotEqual,
+	"<": FmpCalcOperatorLess, "≤": FmpCalcOperatorLessEqual, "<=": FmpCalcOperatorLessEqual,
+	">": FmpCalcOperatorGreater, "≥": FmpCalcOperatorGreaterEqual, ">=": FmpCalcOperatorGreaterEqual,
+	"and": FmpCalcOperatorAnd, "or": FmpCalcOperatorOr, "xor": FmpCalcOperatorXor, "not": FmpCalcOperatorNot,
+}
+
+// ParseCalculation compiles calculation source text in FileMaker syntax into
+// the bytecode stored in the file. Field references are written as
+// Occurrence::Field and resolved against the table occurrences of the file.
+// Whitespace is kept, but comments are not.
+//
+// Get can only be compiled for the information listed in calcGetNames. For
+// anything else, it returns an FmpUnsupportedError.
+func (ctx *FmpFile) ParseCalculation(src string) (*FmpCalculation, error) {
+	return ctx.parseCalculation(src, nil)
+}
+
+// ParseCalculation compiles a calculation of a field of this table, like
+// FmpFile.ParseCalculation. Fields of the table can also be referred to by
+// their name only.
+func (t *FmpTable) ParseCalculation(src string) (*FmpCalculation, error) {
+	return t.file.parseCalculation(src, t)
+}
+
+func (ctx *FmpFile) parseCalculation(src string, table *FmpTable) (*FmpCalculation, error) {
+	tokens, err := lexCalculation(src)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.mu.RLock()
+	_, err = parseCalcTokens(ctx, table, tokens)
+	ctx.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := encodeCalcTokens(tokens)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.decodeCalculation(code, table), nil
+}
+
+// encodeCalcTokens is the inverse of decodeCalcTokens. The tokens must have
+// been parsed, which resolves the fields and variables of Let they refer to.
+func encodeCalcTokens(tokens []calcToken) ([]byte, error) {
+	code := make([]byte, 0)
+	text := func(value []byte) {
+		for first := true; first || len(value) > 0; first = false {
+			n := min(len(value), 0xFF)
+			code = append(code, calcOpText, byte(n))
+			code = append(code, value[:n]...)
+			value = value[n:]
+		}
+	}
+	function := false
+
+	for i, tok := range tokens {
+		space := tok.space
+		if tok.text == "(" && tok.kind == calcTokenPunct && function {
+			space = ""
+		}
+		if len(space) > 0 {
+			code = append(code, calcOpSpace)
+			text(encodeString(space))
+			code = append(code, 0)
+		}
+		function = false
+
+		switch tok.kind {
+		case calcTokenEOF:
+			return code, nil
+
+		case calcTokenNumber:
+			if len(tok.text) > 0xFF {
+				return nil, &FmpCalcSyntaxError{Offset: tok.offset, Message: "number too long"}
+			}
+			code = append(code, calcOpNumber, 0, 0, 0, 0, 0, 0, 0, byte(len(tok.text)))
+			code = append(code, tok.text...)
+			continue
+
+		case calcTokenText:
+			text(encodeString(tok.text))
+			continue
+
+		case calcTokenField, calcTokenName:
+			if len(tok.text) > 0xFF {
+				return nil, &FmpCalcSyntaxError{Offset: tok.offset, Message: "name too long"}
+			}
+			switch {
+			case tok.ref != nil:
+				occID := uint64(0)
+				if tok.ref.Occurrence != nil {
+					occID = tok.ref.Occurrence.ID
+				}
+				code = append(code, calcOpField)
+				code = append(code, encodeLengthPrefixed(occID)...)
+				code = append(code, encodeLengthPrefixed(tok.ref.Column.Index)...)
+				continue
+			case strings.EqualFold(tok.text, "get"):
+				code = append(code, calcOpGet)
+				function = true
+				continue
+			case i > 1 && tokens[i-1].text == "(" && strings.EqualFold(tokens[i-2].text, "get"):
+				if id, ok := calcGetID(tok.text); ok {
+					code = append(code, calcOpGetName, id)
+					continue
+				}
+				return nil, &FmpUnsupportedError{Name: "Get ( " + tok.text + " )"}
+			case strings.HasPrefix(tok.text, "$") || tok.variable:
+				code = append(code, calcOpVariable, byte(len(tok.text)))
+				code = append(code, tok.text...)
+				continue
+			default:
+				code = append(code, calcOpFunction, byte(len(tok.text)))
+				code = append(code, tok.text...)
+				function = true
+				continue
+			}
+
+		case calcTokenPunct:
+			switch tok.text {
+			case "(":
+				code = append(code, calcOpOpen)
+				continue
+			case ")":
+				code = append(code, calcOpClose)
+				continue
+			case ";":
+				code = append(code, calcOpSeparator)
+				continue
+			case "[":
+				code = append(code, calcOpListOpen)
+				continue
+			case "]":
+				code = append(code, calcOpListClose)
+				continue
+			}
+			if op, ok := calcOperatorOpcode(calcOperatorNames[tok.text]); ok {
+				code = append(code, op)
+				continue
+			}
+		}
+		return nil, &FmpUnsupportedError{Name: tok.text}
+	}
+	return code, nil
+}
+
+func calcOperatorOpcode(op FmpCalculationOperator) (byte, bool) {
+	for opcode, operator := range calcOperatorMap {
+		if operator == op {
+			return opcode, true
+		}
+	}
+	return 0, false
+}
+
+func calcGetID(name string) (byte, bool) {
+	for id, getName := range calcGetNames {
+		if strings.EqualFold(getName, name) {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+func lexCalculation(src string) ([]calcToken, error) {
+	tokens := make([]calcToken, 0)
+	pos := 0
+	var space strings.Builder
+	emit := func(tok calcToken) {
+		tok.space = space.String()
+		space.Reset()
+		tokens = append(tokens, tok)
+	}
+
+	for pos < len(src) {
+		r, size := utf8.DecodeRuneInString(src[pos:])
+		start := pos
+		rest := src[pos:]
+
+		switch {
+		case unicode.IsSpace(r):
+			space.WriteString(src[pos : pos+size])
+			pos += size
+
+		case strings.HasPrefix(rest, "//"):
+			end := strings.IndexAny(rest, "\r\n")
+			if end < 0 {
+				end = len(rest)
+			}
+			pos += end
+
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				return nil, &FmpCalcSyntaxError{Offset: start, Message: "unterminated comment"}
+			}
+			pos += end + 4
+
+		case r >= '0' && r <= '9' || r == '.' && len(rest) > 1 && rest[1] >= '0' && rest[1] <= '9':
+			point := false
+			for pos < len(src) && (src[pos] >= '0' && src[pos] <= '9' || src[pos] == '.') {
+				if src[pos] == '.' {
+					if point {
+						return nil, &FmpCalcSyntaxError{Offset: pos, Message: "unexpected second decimal point"}
+					}
+					point = true
+				}
+				pos++
+			}
+			emit(calcToken{kind: calcTokenNumber, offset: start, text: src[start:pos]})
+
+		case r == '"':
+			var b strings.Builder
+			pos++
+			for {
+				if pos >= len(src) {
+					return nil, &FmpCalcSyntaxError{Offset: start, Message: "unterminated text"}
+				}
+				c, size := utf8.DecodeRuneInString(src[pos:])
+				pos += size
+				if c == '"' {
+					break
+				}
+				if c == '\\' && pos < len(src) {
+					c, size = utf8.DecodeRuneInString(src[pos:])
+					pos += size
+				} else if c == '¶' {
+					c = '\r'
+				}
+				b.WriteRune(c)
+			}
+			emit(calcToken{kind: calcTokenText, offset: start, text: b.String()})
+
+		case r == '$' || r == '_' || unicode.IsLetter(r):
+			pos += lexCalcName(rest)
+			tok := calcToken{kind: calcTokenName, offset: start, text: src[start:pos]}
+			if _, ok := calcOperatorNames[strings.ToLower(tok.text)]; ok {
+				tok.kind = calcTokenPunct
+				tok.text = strings.ToLower(tok.text)
+			} else if strings.HasPrefix(src[pos:], "::") {
+				n := lexCalcName(src[pos+2:])
+				if n == 0 {
+					return nil, &FmpCalcSyntaxError{Offset: pos + 2, Message: "expected field name"}
+				}
+				tok.kind = calcTokenField
+				tok.field = src[pos+2 : pos+2+n]
+				pos += 2 + n
+			}
+			emit(tok)
+
+		default:
+			for _, punct := range []string{"<>", "<=", ">=", "≠", "≤", "≥", "(", ")", ";", "[", "]", "+", "-", "*", "/", "^", "&", "=", "<", ">"} {
+				if strings.HasPrefix(rest, punct) {
+					emit(calcToken{kind: calcTokenPunct, offset: start, text: punct})
+					pos += len(punct)
+					break
+				}
+			}
+			if pos == start {
+				return nil, &FmpCalcSyntaxError{Offset: start, Message: fmt.Sprintf("unexpected character '%c'", r)}
+			}
+		}
+	}
+
+	emit(calcToken{kind: calcTokenEOF, offset: len(src)})
+	return tokens, nil
+}
+
+// lexCalcName returns the length of the name at the start of s, including
+// any $ or $$ prefix of a variable.
+func lexCalcName(s string) int {
+	pos := 0
+	for pos < 2 && pos < len(s) && s[pos] == '$' {
+		pos++
+	}
+	for pos < len(s) {
+		r, size := utf8.DecodeRuneInString(s[pos:])
+		if r != '_' && r != '.' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		pos += size
+	}
+	return pos
+}
+
+// parseCalcTokens parses the tokens of a calculation into a tree of nodes.
+// Names that are not variables or functions are fields of the context table,
+// if any. The file must be locked for reading.
+func parseCalcTokens(file *FmpFile, table *FmpTable, tokens []calcToken) (FmpCalcNode, error) {
+	p := &calcParser{file: file, table: table, tokens: tokens}
+	node, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != calcTokenEOF {
+		return nil, p.errorf(tok, "unexpected '%s'", tok.text)
+	}
+	return node, nil
+}
+
+// calcParser parses tokens by precedence climbing.
+type calcParser struct {
+	file   *FmpFile
+	table  *FmpTable
+	tokens []calcToken
+	pos    int
+	scopes [][]string // Names declared by enclosing Let functions
+}
+
+func (p *calcParser) peek() calcToken {
+	return p.tokens[p.pos]
+}
+
+func (p *calcParser) next() calcToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != calcTokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *calcParser) accept(punct string) bool {
+	if tok := p.peek(); tok.kind == calcTokenPunct && tok.text == punct {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *calcParser) expect(punct string) error {
+	if !p.accept(punct) {
+		tok := p.peek()
+		return p.errorf(tok, "expected '%s', got '%s'", punct, tok.text)
+	}
+	return nil
+}
+
+func (p *calcParser) errorf(tok calcToken, format string, args ...any) error {
+	return &FmpCalcSyntaxError{Offset: tok.offset, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *calcParser) parseExpr(minPrecedence int) (FmpCalcNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := p.peek()
+		op, ok := calcOperatorNames[tok.text]
+		if tok.kind != calcTokenPunct || !ok || op == FmpCalcOperatorNot {
+			return left, nil
+		}
+		precedence := op.precedence(false)
+		if precedence < minPrecedence {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseExpr(precedence + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &FmpCalcBinary{Op: op, Left: left, Right: right}
+	}
+}
+
+func (p *calcParser) parseUnary() (FmpCalcNode, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case calcTokenNumber:
+		return &FmpCalcNumber{Value: tok.text}, nil
+
+	case calcTokenText:
+		return &FmpCalcText{Value: tok.text}, nil
+
+	case calcTokenField:
+		if tok.ref != nil {
+			return p.decodedField(tok)
+		}
+		occ := p.file.occurrence(tok.text)
+		if occ == nil || occ.Table == nil {
+			return nil, p.errorf(tok, "unknown table occurrence '%s'", tok.text)
+		}
+		column := occ.Table.column(tok.field)
+		if column == nil {
+			return nil, p.errorf(tok, "unknown field '%s::%s'", tok.text, tok.field)
+		}
+		ref := FmpFieldRef{Occurrence: occ, Column: column}
+		p.tokens[p.pos-1].ref = &ref
+		return &FmpCalcField{Ref: ref}, nil
+
+	case calcTokenName:
+		return p.parseName(tok)
+
+	case calcTokenPunct:
+		switch tok.text {
+		case "not", "-":
+			op := calcOperatorNames[tok.text]
+			operand, err := p.parseExpr(op.precedence(true))
+			if err != nil {
+				return nil, err
+			}
+			return &FmpCalcUnary{Op: op, Operand: operand}, nil
+
+		case "(":
+			inner, err := p.parseExpr(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return &FmpCalcParen{Inner: inner}, nil
+		}
+	}
+
+	if tok.kind == calcTokenEOF {
+		return nil, p.errorf(tok, "unexpected end of calculation")
+	}
+	return nil, p.errorf(tok, "unexpected '%s'", tok.text)
+}
+
+func (p *calcParser) parseName(tok calcToken) (FmpCalcNode, error) {
+	if tok.ref != nil {
+		return p.decodedField(tok)
+	}
+	if strings.HasPrefix(tok.text, "$") || p.declared(tok.text) {
+		p.tokens[p.pos-1].variable = true
+		return &FmpCalcVariable{Name: tok.text}, nil
+	}
+
+	// A name not followed by parentheses is a field of the context table, or
+	// otherwise a function without arguments, such as Pi.
+	if !p.accept("(") {
+		if p.table != nil {
+			if column := p.table.column(tok.text); column != nil {
+				ref := FmpFieldRef{Column: column}
+				p.tokens[p.pos-1].ref = &ref
+				return &FmpCalcField{Ref: ref}, nil
+			}
+		}
+		return &FmpCalcCall{Name: tok.text}, nil
+	}
+
+	switch strings.ToLower(tok.text) {
+	case "get":
+		name := p.next()
+		if name.kind != calcTokenName {
+			return nil, p.errorf(name, "expected name of information to get")
+		}
+		return &FmpCalcGet{Name: name.text}, p.expect(")")
+
+	case "let":
+		return p.parseLet()
+	}
+
+	call := &FmpCalcCall{Name: tok.text}
+	if !p.accept(")") {
+		for {
+			arg, err := p.parseExpr(0)
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, arg)
+			if p.accept(")") {
+				break
+			}
+			if err := p.expect(";"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return call, nil
+}
+
+// decodedField returns the field of a token decoded from bytecode, which
+// refers to it by ID rather than by name.
+func (p *calcParser) decodedField(tok calcToken) (FmpCalcNode, error) {
+	if tok.ref.Column == nil {
+		return nil, p.errorf(tok, "unknown field")
+	}
+	return &FmpCalcField{Ref: *tok.ref}, nil
+}
+
+func (p *calcParser) parseLet() (FmpCalcNode, error) {
+	list := p.accept("[")
+	let := &FmpCalcLet{}
+	p.scopes = append(p.scopes, nil)
+	defer func() { p.scopes = p.scopes[:len(p.scopes)-1] }()
+
+	for {
+		name := p.next()
+		if name.kind != calcTokenName {
+			return nil, p.errorf(name, "expected variable name")
+		}
+		p.tokens[p.pos-1].variable = true
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		value, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+		// Later variables can refer to earlier ones.
+		let.Names = append(let.Names, name.text)
+		let.Values = append(let.Values, value)
+		p.scopes[len(p.scopes)-1] = let.Names
+
+		if !list || p.accept("]") {
+			break
+		}
+		if err := p.expect(";"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.expect(";"); err != nil {
+		return nil, err
+	}
+	body, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	let.Body = body
+	return let, nil
+}
+
+func (p *calcParser) declared(name string) bool {
+	for _, scope := range p.scopes {
+		if slices.Contains(scope, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,9 +1,48 @@
 package fmp
 
+import "fmt"
+
 type FmpError string
 
 func (e FmpError) Error() string { return string(e) }
 
+// FmpParseError describes where in a file decoding went wrong. It wraps one
+// of the sentinel errors below, so errors.Is keeps working, as well as the
+// underlying I/O error if there was one.
+type FmpParseError struct {
+	Err       error    // Sentinel error, such as ErrBadChunk
+	Cause     error    // Underlying error, if any
+	SectorID  uint64   // Sector in which the error occurred, if Offset is set
+	Offset    int64    // Absolute byte offset in the file, or 0 if unknown
+	Opcode    byte     // Chunk opcode, if HasOpcode is set
+	HasOpcode bool     // Whether the error concerns a single chunk
+	Path      []uint64 // Dictionary path at the point of failure, if known
+}
+
+func (e *FmpParseError) Error() string {
+	s := e.Err.Error()
+	if e.Offset > 0 {
+		s += fmt.Sprintf(" at sector %d, offset %d", e.SectorID, e.Offset)
+	}
+	if e.HasOpcode {
+		s += fmt.Sprintf(", opcode 0x%02x", e.Opcode)
+	}
+	if len(e.Path) > 0 {
+		s += ", path " + formatPath(e.Path)
+	}
+	if e.Cause != nil {
+		s += ": " + e.Cause.Error()
+	}
+	return s
+}
+
+func (e *FmpParseError) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Err, e.Cause}
+}
+
 var (
 	ErrRead               = FmpError("read error")
 	ErrBadMagic           = FmpError("bad magic number")
@@ -12,6 +51,21 @@ var (
 	ErrBadSectorCount     = FmpError("bad sector count")
 	ErrBadSectorHeader    = FmpError("bad sector header")
 	ErrBadChunk           = FmpError("bad chunk")
+	ErrSectorLoop         = FmpError("sector chain loops back on itself")
+	ErrBadDictionary      = FmpError("bad dictionary entry")
+	ErrBadCalculation     = FmpError("bad calculation")
+	ErrLocked             = FmpError("file is locked by another process")
+	ErrNoLocking          = FmpError("file locking is not supported on this platform")
+	ErrTxDone             = FmpError("transaction has already been committed or rolled back")
+	ErrUnknownColumn      = FmpError("unknown column")
+	ErrColumnExists       = FmpError("column already exists")
+	ErrUnsupported        = FmpError("unsupported calculation")
+	ErrExternalSource     = FmpError("data source is in another file")
+	ErrValidation         = FmpError("validation failed")
+	ErrNoLookup           = FmpError("field has no lookup")
+	ErrNoSummary          = FmpError("field is not a summary field")
+	ErrNotGlobal          = FmpError("field is not a global field")
+	ErrBadValueList       = FmpError("value list has no known source")
 )
 
 const (
@@ -20,6 +74,32 @@ const (
 	FmpDateTimeLayout = "02/01/2006 15:04:05"
 )
 
+var dataTypeLayouts = map[FmpDataType]string{
+	FmpDataDate: FmpDateLayout,
+	FmpDataTime: FmpTimeLayout,
+	FmpDataTS:   FmpDateTimeLayout,
+}
+
+type FmpSeverity uint8
+
+const (
+	FmpSeverityInfo FmpSeverity = iota
+	FmpSeverityWarning
+	FmpSeverityError
+)
+
+func (s FmpSeverity) String() string {
+	switch s {
+	case FmpSeverityInfo:
+		return "info"
+	case FmpSeverityWarning:
+		return "warning"
+	case FmpSeverityError:
+		return "error"
+	}
+	return fmt.Sprintf("severity(%d)", uint8(s))
+}
+
 type FmpChunkType uint8
 
 const (
@@ -38,7 +118,10 @@ type FmpFieldType uint8
 const (
 	FmpFieldSimple      FmpFieldType = 1
 	FmpFieldCalculation FmpFieldType = 2
-	FmpFieldScript      FmpFieldType = 3
+	FmpFieldSummary     FmpFieldType = 3
+
+	// Deprecated: field type 3 is a summary field. Use FmpFieldSummary.
+	FmpFieldScript = FmpFieldSummary
 )
 
 type FmpFieldStorageType uint8
@@ -61,6 +144,18 @@ const (
 	FmpDataContainer FmpDataType = 6
 )
 
+type FmpRelationOperator uint8
+
+const (
+	FmpRelationEqual FmpRelationOperator = iota
+	FmpRelationNotEqual
+	FmpRelationLess
+	FmpRelationLessOrEqual
+	FmpRelationGreater
+	FmpRelationGreaterOrEqual
+	FmpRelationCartesian
+)
+
 type FmpAutoEnterOption uint8
 
 const (
@@ -103,23 +198,98 @@ var autoEnterOptionMap = map[uint8]FmpAutoEnterOption{
 	136: FmpAutoEnterCalculationReplacingExistingValue,
 }
 
+// FmpCalculationOperator identifies an operator of the calculation language.
+// Operators that FileMaker does not spell with a single ASCII character use
+// another character here, and are printed as FileMaker spells them by String.
 type FmpCalculationOperator byte
 
 const (
-	FmpCalcOperatorAdd         FmpCalculationOperator = '+'
-	FmpCalcOperatorSubtract    FmpCalculationOperator = '-'
-	FmpCalcOperatorMultiply    FmpCalculationOperator = '*'
-	FmpCalcOperatorDivide      FmpCalculationOperator = '/'
-	FmpCalcOperatorConcatenate FmpCalculationOperator = '&'
+	FmpCalcOperatorAdd          FmpCalculationOperator = '+'
+	FmpCalcOperatorSubtract     FmpCalculationOperator = '-'
+	FmpCalcOperatorMultiply     FmpCalculationOperator = '*'
+	FmpCalcOperatorDivide       FmpCalculationOperator = '/'
+	FmpCalcOperatorConcatenate  FmpCalculationOperator = '&'
+	FmpCalcOperatorPower        FmpCalculationOperator = '^'
+	FmpCalcOperatorEqual        FmpCalculationOperator = '='
+	FmpCalcOperatorNotEqual     FmpCalculationOperator = '#'
+	FmpCalcOperatorLess         FmpCalculationOperator = '<'
+	FmpCalcOperatorLessEqual    FmpCalculationOperator = '['
+	FmpCalcOperatorGreater      FmpCalculationOperator = '>'
+	FmpCalcOperatorGreaterEqual FmpCalculationOperator = ']'
+	FmpCalcOperatorAnd          FmpCalculationOperator = 'a'
+	FmpCalcOperatorOr           FmpCalculationOperator = 'o'
+	FmpCalcOperatorXor          FmpCalculationOperator = 'x'
+	FmpCalcOperatorNot          FmpCalculationOperator = '!'
 )
 
-// var calcOperatorMap = map[uint8]FmpCalculationOperator{
-// 	0x25: FmpCalcOperatorAdd,
-// 	0x26: FmpCalcOperatorSubtract,
-// 	0x27: FmpCalcOperatorMultiply,
-// 	0x28: FmpCalcOperatorDivide,
-// 	0x50: FmpCalcOperatorConcatenate,
-// }
+var calcOperatorMap = map[uint8]FmpCalculationOperator{
+	0x25: FmpCalcOperatorAdd,
+	0x26: FmpCalcOperatorSubtract,
+	0x27: FmpCalcOperatorMultiply,
+	0x28: FmpCalcOperatorDivide,
+	0x29: FmpCalcOperatorPower,
+	0x2a: FmpCalcOperatorEqual,
+	0x2b: FmpCalcOperatorNotEqual,
+	0x2c: FmpCalcOperatorLess,
+	0x2d: FmpCalcOperatorLessEqual,
+	0x2e: FmpCalcOperatorGreater,
+	0x2f: FmpCalcOperatorGreaterEqual,
+	0x30: FmpCalcOperatorAnd,
+	0x31: FmpCalcOperatorOr,
+	0x32: FmpCalcOperatorXor,
+	0x33: FmpCalcOperatorNot,
+	0x50: FmpCalcOperatorConcatenate,
+}
+
+func (op FmpCalculationOperator) String() string {
+	switch op {
+	case FmpCalcOperatorNotEqual:
+		return "≠"
+	case FmpCalcOperatorLessEqual:
+		return "≤"
+	case FmpCalcOperatorGreaterEqual:
+		return "≥"
+	case FmpCalcOperatorAnd:
+		return "and"
+	case FmpCalcOperatorOr:
+		return "or"
+	case FmpCalcOperatorXor:
+		return "xor"
+	case FmpCalcOperatorNot:
+		return "not"
+	}
+	return string(rune(op))
+}
+
+// precedence returns how tightly an operator binds, from 1 (or, xor) to 9
+// (unary minus).
+func (op FmpCalculationOperator) precedence(unary bool) int {
+	if unary {
+		if op == FmpCalcOperatorNot {
+			return 3
+		}
+		return 9
+	}
+
+	switch op {
+	case FmpCalcOperatorOr, FmpCalcOperatorXor:
+		return 1
+	case FmpCalcOperatorAnd:
+		return 2
+	case FmpCalcOperatorEqual, FmpCalcOperatorNotEqual, FmpCalcOperatorLess,
+		FmpCalcOperatorLessEqual, FmpCalcOperatorGreater, FmpCalcOperatorGreaterEqual:
+		return 4
+	case FmpCalcOperatorConcatenate:
+		return 5
+	case FmpCalcOperatorAdd, FmpCalcOperatorSubtract:
+		return 6
+	case FmpCalcOperatorMultiply, FmpCalcOperatorDivide:
+		return 7
+	case FmpCalcOperatorPower:
+		return 8
+	}
+	return 0
+}
 
 type FmpScriptStepType uint64
 
@@ -317,3 +487,205 @@ const (
 	FmpScriptTriggerClarisConnectFlow          FmpScriptStepType = 211
 	FmpScriptAssert                            FmpScriptStepType = 255
 )
+
+var scriptStepNames = map[FmpScriptStepType]string{
+	FmpScriptPerformScript:                     "Perform Script",
+	FmpScriptSaveCopyAsXML:                     "Save a Copy as XML",
+	FmpScriptGoToNextField:                     "Go to Next Field",
+	FmpScriptGoToPreviousField:                 "Go to Previous Field",
+	FmpScriptGoToLayout:                        "Go to Layout",
+	FmpScriptNewRecordRequest:                  "New Record/Request",
+	FmpScriptDuplicateRecordRequest:            "Duplicate Record/Request",
+	FmpScriptDeleteRecordRequest:               "Delete Record/Request",
+	FmpScriptDeleteAllRecords:                  "Delete All Records",
+	FmpScriptInsertFromIndex:                   "Insert From Index",
+	FmpScriptInsertFromLastVisited:             "Insert From Last Visited",
+	FmpScriptInsertCurrentDate:                 "Insert Current Date",
+	FmpScriptInsertCurrentTime:                 "Insert Current Time",
+	FmpScriptGoToRecordRequestPage:             "Go to Record/Request/Page",
+	FmpScriptGoToField:                         "Go to Field",
+	FmpScriptCheckSelection:                    "Check Selection",
+	FmpScriptCheckRecord:                       "Check Record",
+	FmpScriptCheckFoundSet:                     "Check Found Set",
+	FmpScriptUnsortRecords:                     "Unsort Records",
+	FmpScriptEnterFindMode:                     "Enter Find Mode",
+	FmpScriptShowAllRecords:                    "Show All Records",
+	FmpScriptModifyLastFind:                    "Modify Last Find",
+	FmpScriptOmitRecord:                        "Omit Record",
+	FmpScriptOmitMultipleRecords:               "Omit Multiple Records",
+	FmpScriptShowOmmitedOnly:                   "Show Omitted Only",
+	FmpScriptPerformFind:                       "Perform Find",
+	FmpScriptShowHideToolbars:                  "Show/Hide Toolbars",
+	FmpScriptViewAs:                            "View As",
+	FmpScriptAdjustWindow:                      "Adjust Window",
+	FmpScriptOpenHelp:                          "Open Help",
+	FmpScriptOpenFile:                          "Open File",
+	FmpScriptCloseFile:                         "Close File",
+	FmpScriptImportRecords:                     "Import Records",
+	FmpScriptExportRecords:                     "Export Records",
+	FmpScriptSaveACopyAs:                       "Save a Copy as",
+	FmpScriptOpenManageDatabase:                "Open Manage Database",
+	FmpScriptSortRecords:                       "Sort Records",
+	FmpScriptRelookupFieldContents:             "Relookup Field Contents",
+	FmpScriptEnterPreviewMode:                  "Enter Preview Mode",
+	FmpScriptPrintSetup:                        "Print Setup",
+	FmpScriptPrint:                             "Print",
+	FmpScriptExitApplication:                   "Exit Application",
+	FmpScriptUndoRedo:                          "Undo/Redo",
+	FmpScriptCut:                               "Cut",
+	FmpScriptCopy:                              "Copy",
+	FmpScriptPaste:                             "Paste",
+	FmpScriptClear:                             "Clear",
+	FmpScriptSelectAll:                         "Select All",
+	FmpScriptRevertRecordRequest:               "Revert Record/Request",
+	FmpScriptEnterBrowserMode:                  "Enter Browse Mode",
+	FmpScriptInsertPicture:                     "Insert Picture",
+	FmpScriptSendEvent:                         "Send Event",
+	FmpScriptInsertCurrentUserName:             "Insert Current User Name",
+	FmpScriptInsertText:                        "Insert Text",
+	FmpScriptPauseResumeScript:                 "Pause/Resume Script",
+	FmpScriptSendMail:                          "Send Mail",
+	FmpScriptSendDDEExecute:                    "Send DDE Execute",
+	FmpScriptDialPhone:                         "Dial Phone",
+	FmpScriptSpeak:                             "Speak",
+	FmpScriptPerformApplescript:                "Perform AppleScript",
+	FmpScriptIf:                                "If",
+	FmpScriptElse:                              "Else",
+	FmpScriptEndIf:                             "End If",
+	FmpScriptLoop:                              "Loop",
+	FmpScriptExitLoopIf:                        "Exit Loop If",
+	FmpScriptEndLoop:                           "End Loop",
+	FmpScriptGoToRelatedRecord:                 "Go to Related Record",
+	FmpScriptCommitRecordsRequests:             "Commit Records/Requests",
+	FmpScriptSetField:                          "Set Field",
+	FmpScriptInsertCalculatedResult:            "Insert Calculated Result",
+	FmpScriptFreezeWindow:                      "Freeze Window",
+	FmpScriptRefreshWindow:                     "Refresh Window",
+	FmpScriptScrollWindow:                      "Scroll Window",
+	FmpScriptNewFile:                           "New File",
+	FmpScriptChangePassword:                    "Change Password",
+	FmpScriptSetMultiUser:                      "Set Multi-User",
+	FmpScriptAllowUserAbort:                    "Allow User Abort",
+	FmpScriptSetErrorCapture:                   "Set Error Capture",
+	FmpScriptShowCustomDialog:                  "Show Custom Dialog",
+	FmpScriptOpenScriptWorkspace:               "Open Script Workspace",
+	FmpScriptBlankLineComment:                  "Blank Line/Comment",
+	FmpScriptHaltScript:                        "Halt Script",
+	FmpScriptReplaceFieldContents:              "Replace Field Contents",
+	FmpScriptShowHideTextRuler:                 "Show/Hide Text Ruler",
+	FmpScriptBeep:                              "Beep",
+	FmpScriptSetUseSystemFormats:               "Set Use System Formats",
+	FmpScriptRecoverFile:                       "Recover File",
+	FmpScriptSaveACopyAsAddOnPackage:           "Save a Copy as Add-on Package",
+	FmpScriptSetZoomLevel:                      "Set Zoom Level",
+	FmpScriptCopyAllRecordsRequests:            "Copy All Records/Requests",
+	FmpScriptGoToPortalRow:                     "Go to Portal Row",
+	FmpScriptCopyRecordRequest:                 "Copy Record/Request",
+	FmpScriptFluchCacheToDisk:                  "Flush Cache to Disk",
+	FmpScriptExitScript:                        "Exit Script",
+	FmpScriptDeletePortalRow:                   "Delete Portal Row",
+	FmpScriptOpenPreferences:                   "Open Preferences",
+	FmpScriptCorrectWord:                       "Correct Word",
+	FmpScriptSpellingOptions:                   "Spelling Options",
+	FmpScriptSelectDictionaries:                "Select Dictionaries",
+	FmpScriptEditUserDictionary:                "Edit User Dictionary",
+	FmpScriptOpenUrl:                           "Open URL",
+	FmpScriptOpenManageValueLists:              "Open Manage Value Lists",
+	FmpScriptOpenSharing:                       "Open Sharing",
+	FmpScriptOpenFileOptions:                   "Open File Options",
+	FmpScriptAllowFormattingBar:                "Allow Formatting Bar",
+	FmpScriptSetNextSerialValue:                "Set Next Serial Value",
+	FmpScriptExecuteSQL:                        "Execute SQL",
+	FmpScriptOpenHosts:                         "Open Hosts",
+	FmpScriptMoveResizeWindow:                  "Move/Resize Window",
+	FmpScriptArrangeAllWindows:                 "Arrange All Windows",
+	FmpScriptCloseWindow:                       "Close Window",
+	FmpScriptNewWindow:                         "New Window",
+	FmpScriptSelectWindow:                      "Select Window",
+	FmpScriptSetWindowTitle:                    "Set Window Title",
+	FmpScriptElseIf:                            "Else If",
+	FmpScriptConstrainFoundSet:                 "Constrain Found Set",
+	FmpScriptExtendFoundSet:                    "Extend Found Set",
+	FmpScriptPerformFindReplace:                "Perform Find/Replace",
+	FmpScriptOpenFindReplace:                   "Open Find/Replace",
+	FmpScriptSetSelection:                      "Set Selection",
+	FmpScriptInsertFile:                        "Insert File",
+	FmpScriptExportFieldContents:               "Export Field Contents",
+	FmpScriptOpenRecordRequest:                 "Open Record/Request",
+	FmpScriptAddAccount:                        "Add Account",
+	FmpScriptDeleteAccount:                     "Delete Account",
+	FmpScriptResetAccountPassword:              "Reset Account Password",
+	FmpScriptEnableAccount:                     "Enable Account",
+	FmpScriptRelogin:                           "Relogin",
+	FmpScriptConvertFile:                       "Convert File",
+	FmpScriptOpenManageDataSources:             "Open Manage Data Sources",
+	FmpScriptSetVariable:                       "Set Variable",
+	FmpScriptInstallMenuSet:                    "Install Menu Set",
+	FmpScriptSaveRecordsAsExcel:                "Save Records as Excel",
+	FmpScriptSaveRecordsAsPdf:                  "Save Records as PDF",
+	FmpScriptGoToObject:                        "Go to Object",
+	FmpScriptSetWebViewer:                      "Set Web Viewer",
+	FmpScriptSetFieldByName:                    "Set Field by Name",
+	FmpScriptInstallOntimerScript:              "Install OnTimer Script",
+	FmpScriptOpenEditSavedFinds:                "Open Edit Saved Finds",
+	FmpScriptPerformQuickFind:                  "Perform Quick Find",
+	FmpScriptOpenManageLayouts:                 "Open Manage Layouts",
+	FmpScriptSaveRecordsAsSnapshotLink:         "Save Records as Snapshot Link",
+	FmpScriptSortRecordsByField:                "Sort Records by Field",
+	FmpScriptFindMatchingRecords:               "Find Matching Records",
+	FmpScriptManageContainers:                  "Manage Containers",
+	FmpScriptInstallPluginFile:                 "Install Plugin File",
+	FmpScriptInsertPdf:                         "Insert PDF",
+	FmpScriptInsertAudioVideo:                  "Insert Audio/Video",
+	FmpScriptInsertFromUrl:                     "Insert from URL",
+	FmpScriptInsertFromDevice:                  "Insert From Device",
+	FmpScriptPerformScriptOnServer:             "Perform Script on Server",
+	FmpScriptOpenManageThemes:                  "Open Manage Themes",
+	FmpScriptShowHideMenubar:                   "Show/Hide Menubar",
+	FmpScriptRefreshObject:                     "Refresh Object",
+	FmpScriptSetLayoutObjectAnimation:          "Set Layout Object Animation",
+	FmpScriptClosePopover:                      "Close Popover",
+	FmpScriptOpenUploadToHost:                  "Open Upload to Host",
+	FmpScriptEnableTouchKeyboard:               "Enable Touch Keyboard",
+	FmpScriptPerformJavascriptInWebViewer:      "Perform JavaScript in Web Viewer",
+	FmpScriptCommentedOut:                      "Commented Out",
+	FmpScriptAvplayerPlay:                      "AVPlayer Play",
+	FmpScriptAvplayerSetPlaybackState:          "AVPlayer Set Playback State",
+	FmpScriptAvplayerSetOptions:                "AVPlayer Set Options",
+	FmpScriptRefreshPortal:                     "Refresh Portal",
+	FmpScriptGetFolderPath:                     "Get Folder Path",
+	FmpScriptTruncateTable:                     "Truncate Table",
+	FmpScriptOpenFavorites:                     "Open Favorites",
+	FmpScriptConfigureRegionMonitorScript:      "Configure Region Monitor Script",
+	FmpScriptConfigureLocalNotification:        "Configure Local Notification",
+	FmpScriptGetFileExists:                     "Get File Exists",
+	FmpScriptGetFileSize:                       "Get File Size",
+	FmpScriptCreateDataFile:                    "Create Data File",
+	FmpScriptOpenDataFile:                      "Open Data File",
+	FmpScriptWriteToDataFile:                   "Write to Data File",
+	FmpScriptReadFromDataFile:                  "Read from Data File",
+	FmpScriptGetDataFilePosition:               "Get Data File Position",
+	FmpScriptSetDataFilePosition:               "Set Data File Position",
+	FmpScriptCloseDataFile:                     "Close Data File",
+	FmpScriptDeleteFile:                        "Delete File",
+	FmpScriptRenameFile:                        "Rename File",
+	FmpScriptSetErrorLogging:                   "Set Error Logging",
+	FmpScriptConfigureNfcReading:               "Configure NFC Reading",
+	FmpScriptConfigureMachineLearningModel:     "Configure Machine Learning Model",
+	FmpScriptExecuteFileMakerDataAPI:           "Execute FileMaker Data API",
+	FmpScriptOpenTransaction:                   "Open Transaction",
+	FmpScriptCommitTransaction:                 "Commit Transaction",
+	FmpScriptRevertTransaction:                 "Revert Transaction",
+	FmpScriptSetSessionIdentifier:              "Set Session Identifier",
+	FmpScriptSetDictionary:                     "Set Dictionary",
+	FmpScriptPerformScriptOnServerWithCallback: "Perform Script on Server with Callback",
+	FmpScriptTriggerClarisConnectFlow:          "Trigger Claris Connect Flow",
+	FmpScriptAssert:                            "Assert",
+}
+
+func (t FmpScriptStepType) String() string {
+	if name, ok := scriptStepNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unknown step %d", uint64(t))
+}
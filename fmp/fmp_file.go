@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"io"
 	"os"
+	"slices"
+	"sync"
 	"time"
 )
 
@@ -18,6 +20,8 @@ const (
 	headerSize = sectorSize
 	magicSize  = len(magicSequence)
 	hbamSize   = len(hbamSequence)
+
+	lockRetryInterval = 50 * time.Millisecond
 )
 
 type FmpFile struct {
@@ -26,76 +30,255 @@ type FmpFile struct {
 	FileSize    uint
 	Sectors     []*FmpSector
 	Chunks      []*FmpChunk
-	Dictionary  *FmpDict
-
-	tables          []*FmpTable
-	numSectors      uint64 // Excludes the header sector
-	currentSectorID uint64
 
+	// Dictionary holds the decoded dictionary of the file. Committing a
+	// transaction changes it, so it is not safe to access directly while
+	// other goroutines may commit. Use DictionaryValue instead.
+	Dictionary *FmpDict
+
+	// Problems lists what could not be decoded when the file was opened in
+	// salvage mode. It is always empty otherwise.
+	Problems []error
+
+	salvage       bool
+	tables        []*FmpTable
+	occurrences   []*FmpTableOccurrence
+	relationships []*FmpRelationship
+	scripts       []*FmpScript
+	layouts       []*FmpLayout
+	valueLists    []*FmpValueList
+	numSectors    uint64 // Excludes the header sector
+
+	now         func() time.Time
+	accountName string
+	userName    string
+	noAutoEnter bool
+	override    bool // Accept values that fail validation the user can override
+
+	// mu guards the tables, their columns and records, and the dictionary.
+	// Readers take a read lock; committing a transaction takes a write lock.
+	mu     sync.RWMutex
 	stream *os.File
+	locked bool
+}
+
+// FmpOpenOptions controls how OpenFileWithOptions opens a file.
+type FmpOpenOptions struct {
+	// ReadOnly opens the file without write access. A shared lock is taken
+	// instead of an exclusive one, so other readers are not blocked.
+	ReadOnly bool
+
+	// LockTimeout is how long to wait for a lock held by another process
+	// before giving up with ErrLocked. Zero fails immediately.
+	LockTimeout time.Duration
+
+	// NoLock disables advisory locking altogether.
+	NoLock bool
+
+	// Salvage keeps decoding past damaged chunks and sectors instead of
+	// failing, much like FileMaker's Recover command. Whatever could not be
+	// decoded is listed in the Problems field of the returned file.
+	Salvage bool
+
+	// Now, AccountName and UserName supply the values that auto-enter
+	// options enter into new and updated records. Now defaults to time.Now,
+	// and the names to those the file was last changed by. NoAutoEnter leaves
+	// fields as given instead.
+	Now         func() time.Time
+	AccountName string
+	UserName    string
+	NoAutoEnter bool
+
+	// OverrideValidation accepts values that fail the validation of fields
+	// that let the user override it, like confirming FileMaker's validation
+	// dialog does.
+	OverrideValidation bool
 }
 
+// OpenFile opens a file for reading and writing, holding an exclusive lock on
+// it until it is closed. Locks are taken with flock on Unix and LockFileEx on
+// Windows. On other platforms, OpenFile fails with ErrNoLocking, and files can
+// only be opened with NoLock.
 func OpenFile(path string) (*FmpFile, error) {
+	return OpenFileWithOptions(path, nil)
+}
+
+// OpenFileWithOptions opens a file as configured by opts, which may be nil.
+// Unless locking is disabled, it fails with ErrLocked if another process holds
+// a conflicting lock for longer than the configured timeout.
+func OpenFileWithOptions(path string, opts *FmpOpenOptions) (*FmpFile, error) {
+	if opts == nil {
+		opts = &FmpOpenOptions{}
+	}
+
 	info, err := os.Stat(path)
 	if err != nil {
 		return nil, err
 	}
 
-	stream, err := os.OpenFile(path, os.O_RDWR, 0)
+	flag := os.O_RDWR
+	if opts.ReadOnly {
+		flag = os.O_RDONLY
+	}
+	stream, err := os.OpenFile(path, flag, 0)
 	if err != nil {
 		return nil, err
 	}
 
-	ctx := &FmpFile{stream: stream, Dictionary: &FmpDict{}}
-	if err := ctx.readHeader(); err != nil {
+	ctx := &FmpFile{
+		stream:      stream,
+		Dictionary:  &FmpDict{},
+		salvage:     opts.Salvage,
+		now:         opts.Now,
+		accountName: opts.AccountName,
+		userName:    opts.UserName,
+		noAutoEnter: opts.NoAutoEnter,
+		override:    opts.OverrideValidation,
+	}
+	if !opts.NoLock {
+		if err := lockFile(stream, !opts.ReadOnly, opts.LockTimeout); err != nil {
+			stream.Close()
+			return nil, err
+		}
+		ctx.locked = true
+	}
+
+	if err := ctx.read(info); err != nil {
+		ctx.Close()
 		return nil, err
 	}
 
+	// Without an account or user name, use those the file records with its
+	// table catalog, which FileMaker stores at keys 64514 and 64513.
+	if ctx.accountName == "" {
+		ctx.accountName = decodeString(ctx.Dictionary.GetValue(3, 16, 1, 64514))
+	}
+	if ctx.userName == "" {
+		ctx.userName = decodeString(ctx.Dictionary.GetValue(3, 16, 1, 64513))
+	}
+	return ctx, nil
+}
+
+func (ctx *FmpFile) read(info os.FileInfo) error {
+	if err := ctx.readHeader(); err != nil {
+		return err
+	}
+
 	ctx.FileSize = uint(info.Size())
 	ctx.numSectors = uint64((ctx.FileSize / sectorSize) - 1)
 	ctx.Sectors = make([]*FmpSector, 0)
-	ctx.stream.Seek(2*sectorSize, io.SeekStart)
 
+	// When salvaging, decoding resumes at the next sector that has not been
+	// read yet wherever the chain breaks, and from then on carries on until
+	// all sectors have been read.
+	id, offset := uint64(0), int64(2*sectorSize)
+	visited := map[uint64]bool{}
+	resynced := false
 	for {
-		sector, err := ctx.readSector()
+		sector, err := ctx.readSector(id, offset)
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return nil, err
+			if err := ctx.problem(err); err != nil {
+				return err
+			}
+			visited[id], resynced = true, true
+			if id, offset = ctx.nextUnvisited(id, visited); id == 0 {
+				break
+			}
+			continue
 		}
 
 		ctx.Sectors = append(ctx.Sectors, sector)
+		visited[sector.ID] = true
 
 		if sector.ID != 0 {
-			err = sector.processChunks(ctx.Dictionary)
-			if err != nil {
-				return nil, err
+			if err := ctx.problem(sector.processChunks(ctx.Dictionary)); err != nil {
+				return err
 			}
 			ctx.Chunks = append(ctx.Chunks, sector.Chunks...)
 		}
 
-		ctx.currentSectorID = sector.NextID
-		if sector.NextID == 0 {
+		if sector.NextID == 0 && !resynced {
+			break
+		}
+		switch {
+		case sector.NextID == 0:
+		case sector.NextID > ctx.numSectors:
+			err = &FmpParseError{Err: ErrBadHeader, SectorID: sector.ID, Offset: offset + 8}
+		case visited[sector.NextID]:
+			// Resuming in the middle of the chain leads back to sectors
+			// that were read already, which is not a loop.
+			if !resynced {
+				err = &FmpParseError{Err: ErrSectorLoop, SectorID: sector.ID, Offset: offset + 8}
+			}
+		default:
+			id, offset = sector.NextID, int64(sector.NextID*sectorSize)
+			continue
+		}
+
+		if err := ctx.problem(err); err != nil {
+			return err
+		}
+		resynced = true
+		if id, offset = ctx.nextUnvisited(sector.ID, visited); id == 0 {
 			break
-		} else if sector.NextID > ctx.numSectors {
-			return nil, ErrBadHeader
-		} else {
-			ctx.stream.Seek(int64(sector.NextID*sectorSize), 0)
 		}
 	}
 
-	ctx.readTables()
-	return ctx, nil
+	if err := ctx.readTables(); err != nil {
+		return err
+	}
+	if err := ctx.readRelationships(); err != nil {
+		return err
+	}
+	if err := ctx.readScripts(); err != nil {
+		return err
+	}
+	if err := ctx.readLayouts(); err != nil {
+		return err
+	}
+	return ctx.readValueLists()
+}
+
+// nextUnvisited returns the ID and offset of the sector to resume at when
+// salvaging a broken sector chain: the first sector after the given one that
+// has not been read yet, or else the first such sector in the file. It returns
+// 0 when all sectors have been read. Sectors 1 and 2 hold the header and the
+// first sector, which is read as sector 0.
+func (ctx *FmpFile) nextUnvisited(after uint64, visited map[uint64]bool) (uint64, int64) {
+	for _, from := range []uint64{after + 1, 3} {
+		for id := max(from, 3); id <= ctx.numSectors; id++ {
+			if !visited[id] {
+				return id, int64(id * sectorSize)
+			}
+		}
+	}
+	return 0, 0
+}
+
+// problem records err and returns nil when salvaging, so that decoding can
+// carry on. Otherwise, it returns err unchanged.
+func (ctx *FmpFile) problem(err error) error {
+	if err == nil || !ctx.salvage {
+		return err
+	}
+	ctx.Problems = append(ctx.Problems, err)
+	return nil
 }
 
 func (ctx *FmpFile) Close() {
+	if ctx.locked {
+		unlockFile(ctx.stream)
+		ctx.locked = false
+	}
 	ctx.stream.Close()
 }
 
 func (ctx *FmpFile) readHeader() error {
 	buf := make([]byte, headerSize)
-	_, err := ctx.stream.Read(buf)
+	_, err := ctx.stream.ReadAt(buf, 0)
 	if err != nil {
 		return err
 	}
@@ -117,57 +300,67 @@ func (ctx *FmpFile) readHeader() error {
 	return nil
 }
 
-func (ctx *FmpFile) readSector() (*FmpSector, error) {
-	debug("---------- Reading sector %d", ctx.currentSectorID)
+func (ctx *FmpFile) readSector(id uint64, offset int64) (*FmpSector, error) {
+	debug("---------- Reading sector %d", id)
 	buf := make([]byte, sectorHeaderSize)
-	n, err := ctx.stream.Read(buf)
+	n, err := ctx.stream.ReadAt(buf, offset)
 
 	if n == 0 {
 		return nil, io.EOF
 	}
 	if err != nil {
-		return nil, ErrRead
+		return nil, &FmpParseError{Err: ErrRead, Cause: err, SectorID: id, Offset: offset}
 	}
 
 	sector := &FmpSector{
-		ID:      ctx.currentSectorID,
+		ID:      id,
 		Deleted: buf[0] > 0,
 		Level:   uint8(buf[1]),
 		PrevID:  decodeVarUint64(buf[4 : 4+4]),
 		NextID:  decodeVarUint64(buf[8 : 8+4]),
 		Chunks:  make([]*FmpChunk, 0),
+		offset:  offset,
 	}
 
-	if ctx.currentSectorID == 0 && sector.PrevID > 0 {
-		return nil, ErrBadSectorHeader
+	if id == 0 && sector.PrevID > 0 {
+		return nil, &FmpParseError{Err: ErrBadSectorHeader, SectorID: id, Offset: offset + 4}
 	}
 
 	sector.Payload = make([]byte, sectorPayloadSize)
-	n, err = ctx.stream.Read(sector.Payload)
+	n, err = ctx.stream.ReadAt(sector.Payload, offset+sectorHeaderSize)
 
 	if n != sectorPayloadSize {
-		return nil, ErrRead
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, &FmpParseError{Err: ErrRead, Cause: err, SectorID: id, Offset: offset + sectorHeaderSize}
 	}
-	if err != nil {
-		return nil, ErrRead
+	if err != nil && err != io.EOF {
+		return nil, &FmpParseError{Err: ErrRead, Cause: err, SectorID: id, Offset: offset + sectorHeaderSize}
 	}
 	return sector, nil
 }
 
-func (ctx *FmpFile) readTables() {
+func (ctx *FmpFile) readTables() error {
 	tables := make([]*FmpTable, 0)
-	ent := ctx.Dictionary.GetEntry(3, 16, 5)
+	ctx.tables = tables
 
-	for path, tableEnt := range *ent.Children {
+	for path, tableEnt := range *ctx.Dictionary.GetChildren(3, 16, 5) {
 		if path < 128 {
 			continue
 		}
 
+		// The catalog entry holds the comment at key 3, and the data
+		// source of shadow tables at key 17.
 		table := &FmpTable{
-			ID:      path,
-			Name:    decodeString(tableEnt.Children.GetValue(16)),
-			Columns: map[uint64]*FmpColumn{},
-			Records: map[uint64]*FmpRecord{},
+			ID:             path,
+			Name:           decodeString(tableEnt.Children.GetValue(16)),
+			Columns:        map[uint64]*FmpColumn{},
+			Records:        map[uint64]*FmpRecord{},
+			Comment:        decodeString(tableEnt.Children.GetValue(3)),
+			ChangedBy:      decodeChangeInfo(tableEnt.Children),
+			ExternalSource: decodeString(tableEnt.Children.GetValue(17)),
+			file:           ctx,
 		}
 
 		tables = append(tables, table)
@@ -175,8 +368,16 @@ func (ctx *FmpFile) readTables() {
 		for colPath, colEnt := range *ctx.Dictionary.GetChildren(table.ID, 3, 5) {
 			name := decodeString(colEnt.Children.GetValue(16))
 			flags := colEnt.Children.GetValue(2)
+			if len(flags) < 26 {
+				err := &FmpParseError{Err: ErrBadDictionary, Path: []uint64{table.ID, 3, 5, colPath, 2}}
+				if err := ctx.problem(err); err != nil {
+					return err
+				}
+				continue
+			}
 
 			column := &FmpColumn{
+				Table:       table,
 				Index:       colPath,
 				Name:        name,
 				Type:        FmpFieldType(flags[0]),
@@ -184,19 +385,64 @@ func (ctx *FmpFile) readTables() {
 				StorageType: FmpFieldStorageType(flags[9]),
 				Repetitions: flags[25],
 				Indexed:     flags[8] == 128,
+				Language:    FmpLanguage(flags[7]),
+				Validation:  decodeValidation(flags),
+				Comment:     decodeString(colEnt.Children.GetValue(3)),
+				ChangedBy:   decodeChangeInfo(colEnt.Children),
+				Calculation: ctx.decodeCalculation(colEnt.Children.GetValue(5, 5), table),
+				Lookup:      decodeLookup(flags, colEnt.Children),
+				Summary:     decodeSummary(flags, colEnt.Children),
+
+				ProhibitModification: flags[10]&0x01 != 0,
+				EvaluateIfEmpty:      flags[11]&0x20 != 0,
+
+				autoEnters: flags[11] != 0,
 			}
 
-			if flags[11] == 1 {
-				column.AutoEnter = autoEnterPresetMap[flags[4]]
+			// The calculation holds the length-prefixed ID of its context
+			// occurrence at key 4, which is 0 for the default, like in the
+			// sample file. The furigana field is at key 11, which is this
+			// package's own as the sample file has none.
+			column.contextID, _, _ = decodeLengthPrefixed(colEnt.Children.GetValue(5, 4), 0)
+			column.furiganaID, _, _ = decodeLengthPrefixed(colEnt.Children.GetValue(11), 0)
+
+			// Evaluating calculations even if all references are empty is an
+			// option on top of the auto-enter option itself.
+			option := flags[11]
+			if option != 0x20 {
+				option &^= 0x20
+			}
+			if option == 1 {
+				// The sample file has the preset at byte 3, not 4.
+				column.AutoEnter = autoEnterPresetMap[flags[3]]
 			} else {
-				column.AutoEnter = autoEnterOptionMap[flags[11]]
+				column.AutoEnter = autoEnterOptionMap[option]
+			}
+			if flags[10]&0x02 != 0 {
+				column.AutoEnter = FmpAutoEnterSerialNumber
+				column.SerialOnCommit = true
+				column.autoEnters = true
+			}
+
+			column.readSerial(colEnt.Children)
+
+			path := []uint64{table.ID, 3, 5, colPath}
+			if err := ctx.readValidationDetails(column, colEnt.Children, path); err != nil {
+				return err
 			}
 
 			table.Columns[column.Index] = column
+			if colPath > table.lastColumnID {
+				table.lastColumnID = colPath
+			}
 		}
 
+		// Byte 1 holds the operation of summary fields rather than their
+		// data type, which follows from the field they summarize.
+		table.resolveColumns()
+
 		for recPath, recEnt := range *ctx.Dictionary.GetChildren(table.ID, 5) {
-			record := &FmpRecord{Index: recPath, Values: make(map[uint64]string)}
+			record := &FmpRecord{Table: table, Index: recPath, Values: make(map[uint64]string)}
 			table.Records[record.Index] = record
 
 			if recPath > table.lastRecordID {
@@ -207,32 +453,70 @@ func (ctx *FmpFile) readTables() {
 				record.Values[colIndex] = decodeString(value.Value)
 			}
 		}
+		table.readRecordCount()
 	}
 
 	ctx.tables = tables
+	return nil
 }
 
+// NewSector allocates a new sector at the end of the file.
 func (ctx *FmpFile) NewSector() (*FmpSector, error) {
-	id := uint64(len(ctx.Sectors)) + 1
-	prevID := id - 2
+	tx := ctx.Begin()
+	sector, err := tx.NewSector()
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return sector, tx.Commit()
+}
 
-	ctx.Sectors[prevID].NextID = uint64(id)
-	_, err := ctx.stream.WriteAt(encodeUint(4, int(id)), int64((id-1)*sectorSize)+4)
+// appendSectors appends the given sectors to the file. If writing one of them
+// fails, the file is truncated back to its old size, the link of the last
+// sector is restored, and the sectors are not added to Sectors.
+func (ctx *FmpFile) appendSectors(sectors []*FmpSector) error {
+	if len(sectors) == 0 {
+		return nil
+	}
 
+	info, err := ctx.stream.Stat()
 	if err != nil {
-		return nil, err
+		return err
+	}
+	count := len(ctx.Sectors)
+	last := ctx.Sectors[count-1]
+	nextID := last.NextID
+	linkOffset := int64(count)*sectorSize + 4
+	link := make([]byte, 4)
+	if _, err := ctx.stream.ReadAt(link, linkOffset); err != nil {
+		return err
 	}
 
-	sector := &FmpSector{
-		ID:      uint64(id),
-		Deleted: false,
-		Level:   0,
-		PrevID:  prevID,
-		NextID:  0,
-		Chunks:  make([]*FmpChunk, 0),
+	for _, sector := range sectors {
+		if err := ctx.appendSector(sector); err != nil {
+			ctx.stream.Truncate(info.Size())
+			ctx.stream.WriteAt(link, linkOffset)
+			for _, appended := range ctx.Sectors[count:] {
+				appended.ID, appended.PrevID, appended.offset = 0, 0, 0
+			}
+			ctx.Sectors = ctx.Sectors[:count]
+			last.NextID = nextID
+			return err
+		}
 	}
+	return nil
+}
 
-	ctx.Sectors = append(ctx.Sectors, sector)
+// appendSector assigns the next sector ID to a sector, links it to the last
+// sector and writes it to the end of the file.
+func (ctx *FmpFile) appendSector(sector *FmpSector) error {
+	id := uint64(len(ctx.Sectors)) + 1
+	prevID := id - 2
+
+	_, err := ctx.stream.WriteAt(encodeUint(4, int(id)), int64((id-1)*sectorSize)+4)
+	if err != nil {
+		return err
+	}
 
 	sectorBuf := make([]byte, sectorSize)
 	sectorBuf[0] = 0 // deleted
@@ -242,12 +526,26 @@ func (ctx *FmpFile) NewSector() (*FmpSector, error) {
 
 	_, err = ctx.stream.WriteAt(sectorBuf, int64((id+1)*sectorSize))
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return sector, nil
+
+	ctx.Sectors[prevID].NextID = id
+	sector.ID = id
+	sector.PrevID = prevID
+	sector.offset = int64((id + 1) * sectorSize)
+	ctx.Sectors = append(ctx.Sectors, sector)
+	return nil
 }
 
-func (ctx *FmpFile) setValue(path []uint64, value []byte) {
-	// ctx.Dictionary.set(path, value)
+// DictionaryValue returns a copy of the value at the given dictionary path.
+// Unlike accessing Dictionary directly, it is safe to call while other
+// goroutines commit changes to the file.
+func (ctx *FmpFile) DictionaryValue(path ...uint64) []byte {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+	return slices.Clone(ctx.Dictionary.GetValue(path...))
+}
 
+func (ctx *FmpFile) setValue(path []uint64, value []byte) {
+	ctx.Dictionary.set(path, value)
 }
@@ -0,0 +1,76 @@
+package fmp
+
+type fmpPendingGlobal struct {
+	column *FmpColumn
+	value  string
+}
+
+// Global returns the value of a global field, or an empty string if the
+// field does not exist. Records return the same value for global fields.
+func (t *FmpTable) Global(name string) string {
+	t.file.mu.RLock()
+	defer t.file.mu.RUnlock()
+
+	column := t.column(name)
+	if column == nil || column.StorageType != FmpFieldStorageGlobal {
+		return ""
+	}
+	return t.globalLocked(column)
+}
+
+// globalPath returns where the value of a global field is stored: at key 10
+// of its field definition. The sample file has no global fields, so this
+// location is this package's own.
+func globalPath(column *FmpColumn) []uint64 {
+	return []uint64{column.Table.ID, 3, 5, column.Index, 10}
+}
+
+func (t *FmpTable) globalLocked(column *FmpColumn) string {
+	return decodeString(t.file.Dictionary.GetValue(globalPath(column)...))
+}
+
+// SetGlobal changes the value of a global field.
+func (t *FmpTable) SetGlobal(name string, value string) error {
+	tx := t.file.Begin()
+	if err := tx.SetGlobal(t, name, value); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetGlobal changes the value of a global field of the given table. The value
+// is validated like those of other fields. It returns ErrNotGlobal if the
+// field is not a global field.
+func (tx *FmpTransaction) SetGlobal(t *FmpTable, name string, value string) error {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	column := t.Column(name)
+	if column == nil {
+		column = tx.pendingColumn(t, name)
+	}
+	if column == nil {
+		return ErrUnknownColumn
+	}
+	if column.StorageType != FmpFieldStorageGlobal {
+		return ErrNotGlobal
+	}
+	return tx.setGlobals([]fmpPendingGlobal{{column: column, value: value}})
+}
+
+// setGlobals validates the values of global fields and, if all of them pass,
+// stores them when the transaction is committed.
+func (tx *FmpTransaction) setGlobals(globals []fmpPendingGlobal) error {
+	for _, global := range globals {
+		record := &FmpRecord{Table: global.column.Table, Values: map[uint64]string{global.column.Index: global.value}}
+		if err := tx.validateValue(global.column, record); err != nil {
+			return err
+		}
+	}
+	for _, global := range globals {
+		tx.setValue(globalPath(global.column), encodeString(global.value))
+	}
+	return nil
+}
@@ -0,0 +1,383 @@
+package fmp
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FmpLanguage is the language a field is indexed and sorted in.
+type FmpLanguage uint8
+
+const (
+	FmpLanguageUnicode       FmpLanguage = 2
+	FmpLanguageDefault       FmpLanguage = 3
+	FmpLanguageCatalan       FmpLanguage = 16
+	FmpLanguageCroatian      FmpLanguage = 17
+	FmpLanguageCzech         FmpLanguage = 18
+	FmpLanguageDanish        FmpLanguage = 19
+	FmpLanguageDutch         FmpLanguage = 20
+	FmpLanguageEnglish       FmpLanguage = 21
+	FmpLanguageFinnish       FmpLanguage = 22
+	FmpLanguageFinnishVW     FmpLanguage = 23
+	FmpLanguageFrench        FmpLanguage = 24
+	FmpLanguageGerman        FmpLanguage = 25
+	FmpLanguageGermanA       FmpLanguage = 26
+	FmpLanguageGreek         FmpLanguage = 27
+	FmpLanguageHungarian     FmpLanguage = 28
+	FmpLanguageIcelandic     FmpLanguage = 29
+	FmpLanguageItalian       FmpLanguage = 30
+	FmpLanguageJapanese      FmpLanguage = 31
+	FmpLanguageNorwegian     FmpLanguage = 32
+	FmpLanguagePolish        FmpLanguage = 33
+	FmpLanguagePortuguese    FmpLanguage = 34
+	FmpLanguageRomanian      FmpLanguage = 35
+	FmpLanguageRussian       FmpLanguage = 36
+	FmpLanguageSlovak        FmpLanguage = 37
+	FmpLanguageSlovenian     FmpLanguage = 38
+	FmpLanguageSpanishModern FmpLanguage = 39
+	FmpLanguageSpanish       FmpLanguage = 40
+	FmpLanguageSwedish       FmpLanguage = 41
+	FmpLanguageSwedishVW     FmpLanguage = 42
+	FmpLanguageTurkish       FmpLanguage = 43
+	FmpLanguageUkrainian     FmpLanguage = 44
+	FmpLanguageChinesePinyin FmpLanguage = 45
+	FmpLanguageChineseStroke FmpLanguage = 46
+	FmpLanguageHebrew        FmpLanguage = 47
+	FmpLanguageHindi         FmpLanguage = 48
+	FmpLanguageArabic        FmpLanguage = 49
+	FmpLanguageEstonian      FmpLanguage = 50
+	FmpLanguageLithuanian    FmpLanguage = 51
+	FmpLanguageLatvian       FmpLanguage = 52
+	FmpLanguageSerbianLatin  FmpLanguage = 53
+	FmpLanguageFarsi         FmpLanguage = 54
+	FmpLanguageBulgarian     FmpLanguage = 55
+	FmpLanguageVietnamese    FmpLanguage = 56
+	FmpLanguageThai          FmpLanguage = 57
+	FmpLanguageGreekMixed    FmpLanguage = 58
+	FmpLanguageBengali       FmpLanguage = 59
+	FmpLanguageTelugu        FmpLanguage = 60
+	FmpLanguageMarathi       FmpLanguage = 61
+	FmpLanguageTamil         FmpLanguage = 62
+	FmpLanguageGujarati      FmpLanguage = 63
+	FmpLanguageKannada       FmpLanguage = 64
+	FmpLanguageMalayalam     FmpLanguage = 65
+	FmpLanguagePanjabi       FmpLanguage = 67
+	FmpLanguageKorean        FmpLanguage = 76
+)
+
+var languageNames = map[FmpLanguage]string{
+	FmpLanguageUnicode:       "Unicode",
+	FmpLanguageDefault:       "Default",
+	FmpLanguageCatalan:       "Catalan",
+	FmpLanguageCroatian:      "Croatian",
+	FmpLanguageCzech:         "Czech",
+	FmpLanguageDanish:        "Danish",
+	FmpLanguageDutch:         "Dutch",
+	FmpLanguageEnglish:       "English",
+	FmpLanguageFinnish:       "Finnish",
+	FmpLanguageFinnishVW:     "Finnish (v<>w)",
+	FmpLanguageFrench:        "French",
+	FmpLanguageGerman:        "German",
+	FmpLanguageGermanA:       "German (ä=a)",
+	FmpLanguageGreek:         "Greek",
+	FmpLanguageHungarian:     "Hungarian",
+	FmpLanguageIcelandic:     "Icelandic",
+	FmpLanguageItalian:       "Italian",
+	FmpLanguageJapanese:      "Japanese",
+	FmpLanguageNorwegian:     "Norwegian",
+	FmpLanguagePolish:        "Polish",
+	FmpLanguagePortuguese:    "Portuguese",
+	FmpLanguageRomanian:      "Romanian",
+	FmpLanguageRussian:       "Russian",
+	FmpLanguageSlovak:        "Slovak",
+	FmpLanguageSlovenian:     "Slovenian",
+	FmpLanguageSpanishModern: "Spanish (Modern)",
+	FmpLanguageSpanish:       "Spanish",
+	FmpLanguageSwedish:       "Swedish",
+	FmpLanguageSwedishVW:     "Swedish (v<>w)",
+	FmpLanguageTurkish:       "Turkish",
+	FmpLanguageUkrainian:     "Ukrainian",
+	FmpLanguageChinesePinyin: "Chinese (Pinyin)",
+	FmpLanguageChineseStroke: "Chinese (Stroke)",
+	FmpLanguageHebrew:        "Hebrew",
+	FmpLanguageHindi:         "Hindi",
+	FmpLanguageArabic:        "Arabic",
+	FmpLanguageEstonian:      "Estonian",
+	FmpLanguageLithuanian:    "Lithuanian",
+	FmpLanguageLatvian:       "Latvian",
+	FmpLanguageSerbianLatin:  "Serbian (Latin)",
+	FmpLanguageFarsi:         "Farsi",
+	FmpLanguageBulgarian:     "Bulgarian",
+	FmpLanguageVietnamese:    "Vietnamese",
+	FmpLanguageThai:          "Thai",
+	FmpLanguageGreekMixed:    "Greek (Mixed)",
+	FmpLanguageBengali:       "Bengali",
+	FmpLanguageTelugu:        "Telugu",
+	FmpLanguageMarathi:       "Marathi",
+	FmpLanguageTamil:         "Tamil",
+	FmpLanguageGujarati:      "Gujarati",
+	FmpLanguageKannada:       "Kannada",
+	FmpLanguageMalayalam:     "Malayalam",
+	FmpLanguagePanjabi:       "Panjabi",
+	FmpLanguageKorean:        "Korean",
+}
+
+func (l FmpLanguage) String() string {
+	if name, ok := languageNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("Language %d", uint8(l))
+}
+
+// collationRule sorts letters right after another one, in the order given.
+// A letter may be more than one character, like the Czech ch.
+type collationRule struct {
+	after   rune
+	letters []string
+}
+
+// languageRules holds the letters that languages sort differently from
+// English. Letters with diacritics that are not listed sort with their base
+// letter.
+var languageRules = map[FmpLanguage][]collationRule{
+	FmpLanguageCroatian:      {{'c', []string{"č", "ć"}}, {'d', []string{"dž", "đ"}}, {'l', []string{"lj"}}, {'n', []string{"nj"}}, {'s', []string{"š"}}, {'z', []string{"ž"}}},
+	FmpLanguageCzech:         {{'c', []string{"č"}}, {'h', []string{"ch"}}, {'r', []string{"ř"}}, {'s', []string{"š"}}, {'z', []string{"ž"}}},
+	FmpLanguageDanish:        {{'z', []string{"æ", "ø", "å"}}},
+	FmpLanguageEstonian:      {{'s', []string{"š"}}, {'z', []string{"ž"}}, {'w', []string{"õ", "ä", "ö", "ü"}}},
+	FmpLanguageFinnish:       {{'z', []string{"å", "ä", "ö"}}},
+	FmpLanguageFinnishVW:     {{'z', []string{"å", "ä", "ö"}}},
+	FmpLanguageHungarian:     {{'c', []string{"cs"}}, {'d', []string{"dz", "dzs"}}, {'g', []string{"gy"}}, {'l', []string{"ly"}}, {'n', []string{"ny"}}, {'o', []string{"ö"}}, {'s', []string{"sz"}}, {'t', []string{"ty"}}, {'u', []string{"ü"}}, {'z', []string{"zs"}}},
+	FmpLanguageIcelandic:     {{'a', []string{"á"}}, {'d', []string{"ð"}}, {'e', []string{"é"}}, {'i', []string{"í"}}, {'o', []string{"ó"}}, {'u', []string{"ú"}}, {'y', []string{"ý"}}, {'z', []string{"þ", "æ", "ö"}}},
+	FmpLanguageLatvian:       {{'c', []string{"č"}}, {'g', []string{"ģ"}}, {'k', []string{"ķ"}}, {'l', []string{"ļ"}}, {'n', []string{"ņ"}}, {'s', []string{"š"}}, {'z', []string{"ž"}}},
+	FmpLanguageLithuanian:    {{'c', []string{"č"}}, {'s', []string{"š"}}, {'z', []string{"ž"}}},
+	FmpLanguageNorwegian:     {{'z', []string{"æ", "ø", "å"}}},
+	FmpLanguagePolish:        {{'a', []string{"ą"}}, {'c', []string{"ć"}}, {'e', []string{"ę"}}, {'l', []string{"ł"}}, {'n', []string{"ń"}}, {'o', []string{"ó"}}, {'s', []string{"ś"}}, {'z', []string{"ź", "ż"}}},
+	FmpLanguageRomanian:      {{'a', []string{"ă", "â"}}, {'i', []string{"î"}}, {'s', []string{"ș", "ş"}}, {'t', []string{"ț", "ţ"}}},
+	FmpLanguageSerbianLatin:  {{'c', []string{"č", "ć"}}, {'d', []string{"dž", "đ"}}, {'l', []string{"lj"}}, {'n', []string{"nj"}}, {'s', []string{"š"}}, {'z', []string{"ž"}}},
+	FmpLanguageSlovak:        {{'a', []string{"ä"}}, {'c', []string{"č"}}, {'h', []string{"ch"}}, {'o', []string{"ô"}}, {'r', []string{"ř"}}, {'s', []string{"š"}}, {'z', []string{"ž"}}},
+	FmpLanguageSlovenian:     {{'c', []string{"č"}}, {'s', []string{"š"}}, {'z', []string{"ž"}}},
+	FmpLanguageSpanishModern: {{'n', []string{"ñ"}}},
+	FmpLanguageSpanish:       {{'c', []string{"ch"}}, {'l', []string{"ll"}}, {'n', []string{"ñ"}}},
+	FmpLanguageSwedish:       {{'z', []string{"å", "ä", "ö"}}},
+	FmpLanguageSwedishVW:     {{'z', []string{"å", "ä", "ö"}}},
+	FmpLanguageTurkish:       {{'c', []string{"ç"}}, {'g', []string{"ğ"}}, {'h', []string{"ı"}}, {'o', []string{"ö"}}, {'s', []string{"ş"}}, {'u', []string{"ü"}}},
+}
+
+// languageExpansions holds letters that sort as if they were spelled
+// differently, like the German ä, which sorts as ae. The Swedish and Finnish
+// v and w sort as the same letter, unless the v<>w variant is used.
+var languageExpansions = map[FmpLanguage]map[rune]string{
+	FmpLanguageGerman:  {'ä': "ae", 'ö': "oe", 'ü': "ue"},
+	FmpLanguageSwedish: {'w': "v"},
+	FmpLanguageFinnish: {'w': "v"},
+}
+
+// baseLetters maps letters with diacritics and ligatures to the letters they
+// sort with.
+var baseLetters = func() map[rune]string {
+	m := map[rune]string{'æ': "ae", 'œ': "oe", 'ß': "ss", 'þ': "th", 'ð': "d", 'ı': "i"}
+	for _, group := range []string{
+		"aàáâãäåāăą", "cçćĉċč", "dďđ", "eèéêëēĕėęě", "gĝğġģ", "hĥħ", "iìíîïĩīĭį",
+		"jĵ", "kķ", "lĺļľŀł", "nñńņňŉ", "oòóôõöøōŏő", "rŕŗř", "sśŝşšș", "tţťŧț",
+		"uùúûüũūŭůűų", "wŵ", "yýÿŷ", "zźżž",
+	} {
+		base, size := utf8.DecodeRuneInString(group)
+		for _, r := range group[size:] {
+			m[r] = string(base)
+		}
+	}
+	return m
+}()
+
+// Compare compares two texts in the sort order of the language, returning a
+// negative number if a sorts before b, and a positive number if it sorts after
+// it. Like in FileMaker, case is ignored, and letters with diacritics sort
+// with their base letter unless the language sorts them as separate letters,
+// in which case they sort after it. Texts that only differ in diacritics are
+// ordered by them.
+//
+// Japanese sorts kana in the order of the syllabary, treating hiragana,
+// katakana and half-width katakana alike, and small and voiced kana like their
+// base kana. Kana sort after Latin text and before kanji, which sort by code
+// point.
+//
+// The Unicode language compares code points, so it is case-sensitive. Other
+// languages without rules of their own, including those written in other
+// scripts, compare like Default and English.
+func (l FmpLanguage) Compare(a, b string) int {
+	if l == FmpLanguageUnicode {
+		return strings.Compare(a, b)
+	}
+	lowerA, lowerB := strings.ToLower(a), strings.ToLower(b)
+	if c := slices.Compare(l.collationKey(lowerA), l.collationKey(lowerB)); c != 0 {
+		return c
+	}
+	return strings.Compare(lowerA, lowerB)
+}
+
+// collationKey turns a lowercase text into weights that sort in the order of
+// the language. Letters sorted after another one are weighed just above it.
+func (l FmpLanguage) collationKey(s string) []uint32 {
+	if l == FmpLanguageJapanese {
+		s = foldKana(s)
+	}
+	rules := languageRules[l]
+	expansions := languageExpansions[l]
+	key := make([]uint32, 0, len(s))
+
+	for len(s) > 0 {
+		weight, size := uint32(0), 0
+		for _, rule := range rules {
+			for i, letter := range rule.letters {
+				if len(letter) > size && strings.HasPrefix(s, letter) {
+					weight, size = uint32(rule.after)<<8|uint32(i+1), len(letter)
+				}
+			}
+		}
+		if size > 0 {
+			key = append(key, weight)
+			s = s[size:]
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+		if expansion, ok := expansions[r]; ok {
+			for _, e := range expansion {
+				key = append(key, uint32(e)<<8)
+			}
+		} else if base, ok := baseLetters[r]; ok {
+			for _, b := range base {
+				key = append(key, uint32(b)<<8)
+			}
+		} else {
+			key = append(key, uint32(unicode.ToLower(r))<<8)
+		}
+	}
+	return key
+}
+
+// halfWidthKatakana holds the full-width forms of U+FF66 to U+FF9D.
+var halfWidthKatakana = []rune("ヲァィゥェォャュョッーアイウエオカキクケコサシスセソタチツテトナニヌネノハヒフヘホマミムメモヤユヨラリルレロワン")
+
+// baseKana maps small and voiced hiragana to the kana they sort with, and
+// kanaVowels maps kana to their vowel, which the long vowel mark repeats.
+var baseKana, kanaVowels = func() (map[rune]rune, map[rune]rune) {
+	base := map[rune]rune{'っ': 'つ', 'ゎ': 'わ', 'ゕ': 'か', 'ゖ': 'け', 'ゔ': 'う'}
+	for _, r := range "かきくけこさしすせそたちつてとはひふへほ" {
+		base[r+1] = r
+	}
+	for _, r := range "はひふへほ" {
+		base[r+2] = r
+	}
+	for _, r := range "あいうえおやゆよ" {
+		base[r-1] = r
+	}
+
+	vowels := make(map[rune]rune)
+	syllabary := []rune("あいうえおかきくけこさしすせそたちつてとなにぬねのはひふへほまみむめもや ゆ よらりるれろわゐ ゑを")
+	for i, r := range syllabary {
+		if r != ' ' {
+			vowels[r] = syllabary[i%5]
+		}
+	}
+	return base, vowels
+}()
+
+// foldKana turns katakana into hiragana, and small and voiced kana into their
+// base kana. Long vowel marks become the vowel they repeat, and iteration
+// marks the kana they repeat. Full-width Latin letters and digits become their
+// ASCII forms.
+func foldKana(s string) string {
+	var b strings.Builder
+	var prev rune
+	for _, r := range s {
+		switch {
+		case r >= 0xFF01 && r <= 0xFF5E:
+			r -= 0xFEE0
+		case r >= 0xFF66 && r <= 0xFF9D:
+			r = halfWidthKatakana[r-0xFF66]
+		case r == 0x3099 || r == 0x309A || r == 0xFF9E || r == 0xFF9F:
+			continue // Combining voiced sound marks
+		}
+		switch {
+		case r >= 'ァ' && r <= 'ヶ':
+			r -= 0x60
+		case r >= 'ヷ' && r <= 'ヺ':
+			r = []rune("わゐゑを")[r-'ヷ']
+		}
+		if base, ok := baseKana[r]; ok {
+			r = base
+		}
+		switch r {
+		case 'ー':
+			if vowel, ok := kanaVowels[prev]; ok {
+				r = vowel
+			}
+		case 'ゝ', 'ゞ', 'ヽ', 'ヾ':
+			if prev != 0 {
+				r = prev
+			}
+		}
+		b.WriteRune(r)
+		prev = r
+	}
+	return b.String()
+}
+
+// Compare compares two values of the field, like FileMaker sorts them. Text
+// is compared in the language of the field, and other values as their data
+// type.
+func (c *FmpColumn) Compare(a, b string) int {
+	if c.DataType == FmpDataText && c.Language != 0 {
+		return c.Language.Compare(a, b)
+	}
+	return compareValues(a, b, c.DataType)
+}
+
+type FmpSortField struct {
+	Field      FmpFieldRef
+	Descending bool
+}
+
+// SortRecords sorts records of the table in place, in the given order, like
+// FileMaker sorts a found set. Values of related fields are taken from the
+// first related record. Records with an empty value sort last.
+func (t *FmpTable) SortRecords(records []*FmpRecord, order ...FmpSortField) {
+	slices.SortStableFunc(records, func(a, b *FmpRecord) int {
+		for _, field := range order {
+			if field.Field.Column == nil {
+				continue
+			}
+			va, vb := sortValue(a, field.Field), sortValue(b, field.Field)
+			c := field.Field.Column.Compare(va, vb)
+			switch {
+			case va == "" && vb != "":
+				c = 1
+			case va != "" && vb == "":
+				c = -1
+			case field.Descending:
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+}
+
+func sortValue(record *FmpRecord, ref FmpFieldRef) string {
+	if ref.Column.Table == record.Table {
+		return record.Value(ref.Column.Name)
+	}
+	if ref.Occurrence != nil {
+		for related := range record.Related(ref.Occurrence.Name) {
+			return related.Value(ref.Column.Name)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,214 @@
+package fmp
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+)
+
+type FmpLayout struct {
+	ID      uint64
+	Name    string
+	Folder  string             // Name of the folder the layout is in, if any
+	Parts   []*FmpLayoutPart   // Ordered from top to bottom
+	Objects []*FmpLayoutObject // Ordered from back to front
+	Height  float64            // In points, down to the bottom of the last part
+
+	// Occurrence is the table occurrence the layout shows records from. Where
+	// the file stores it is not known, so it is only set in files with a
+	// single table occurrence, which all layouts must then show.
+	Occurrence *FmpTableOccurrence
+
+	// Width is in points, up to the right edge of the rightmost object. Where
+	// the file stores the width set in Layout mode is not known.
+	Width float64
+}
+
+// FmpLayoutPart is a horizontal band of a layout, such as its header or body.
+// Its bounds are in points from the top of the layout.
+type FmpLayoutPart struct {
+	Type   FmpLayoutPartType
+	Top    float64
+	Bottom float64
+}
+
+type FmpLayoutPartType uint64
+
+const (
+	FmpLayoutPartBody   FmpLayoutPartType = 4
+	FmpLayoutPartHeader FmpLayoutPartType = 12
+)
+
+func (t FmpLayoutPartType) String() string {
+	switch t {
+	case FmpLayoutPartBody:
+		return "Body"
+	case FmpLayoutPartHeader:
+		return "Header"
+	}
+	return fmt.Sprintf("Part %d", uint64(t))
+}
+
+// Layouts returns the layouts in the file, ordered by ID. Folders are not
+// returned themselves, but are reflected in the Folder of their layouts.
+func (ctx *FmpFile) Layouts() []*FmpLayout {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+	return slices.Clone(ctx.layouts)
+}
+
+// Layout returns the layout with the given name, or nil.
+func (ctx *FmpFile) Layout(name string) *FmpLayout {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+
+	for _, layout := range ctx.layouts {
+		if layout.Name == name {
+			return layout
+		}
+	}
+	return nil
+}
+
+// readLayouts decodes the layout catalog.
+//
+// The catalog at [4].[1].[7].[layout] holds the name at key 16, and key 2 in
+// the format of Protocol Buffers, which is not decoded. Like in the script
+// catalog, key 4 holds the length-prefixed ID of the folder an entry is in.
+// Folders are the entries without a layout at [4].[5].[layout].
+//
+// The parts of a layout live at [4].[5].[layout].[3].[part] key 2, also as
+// Protocol Buffers: field 1 holds the part type, and fields 4 and 5 the top
+// and bottom as doubles.
+func (ctx *FmpFile) readLayouts() error {
+	ctx.layouts = make([]*FmpLayout, 0)
+	catalog := ctx.Dictionary.GetChildren(4, 1, 7)
+
+	var occurrence *FmpTableOccurrence
+	if len(ctx.occurrences) == 1 {
+		occurrence = ctx.occurrences[0]
+	}
+
+	for id, ent := range *catalog {
+		if ent.Children == nil || ctx.Dictionary.GetEntry(4, 5, id) == nil {
+			continue
+		}
+
+		layout := &FmpLayout{
+			ID:         id,
+			Name:       decodeString(ent.Children.GetValue(16)),
+			Occurrence: occurrence,
+			Parts:      make([]*FmpLayoutPart, 0),
+			Objects:    make([]*FmpLayoutObject, 0),
+		}
+		if parentID, _, ok := decodeLengthPrefixed(ent.Children.GetValue(4), 0); ok {
+			layout.Folder = decodeString(catalog.GetValue(parentID, 16))
+		}
+
+		parts := ctx.Dictionary.GetChildren(4, 5, id, 3)
+		for _, key := range sortedKeys(parts) {
+			ent := (*parts)[key]
+			if ent.Children == nil || ent.Children.GetValue(2) == nil {
+				continue
+			}
+			part, ok := decodeLayoutPart(ent.Children.GetValue(2))
+			if !ok {
+				if err := ctx.problem(&FmpParseError{Err: ErrBadDictionary, Path: []uint64{4, 5, id, 3, key, 2}}); err != nil {
+					return err
+				}
+				continue
+			}
+			layout.Parts = append(layout.Parts, part)
+			layout.Height = max(layout.Height, part.Bottom)
+		}
+
+		if err := ctx.readLayoutObjects(layout); err != nil {
+			return err
+		}
+
+		ctx.layouts = append(ctx.layouts, layout)
+	}
+
+	slices.SortFunc(ctx.layouts, func(a, b *FmpLayout) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return nil
+}
+
+func decodeLayoutPart(data []byte) (*FmpLayoutPart, bool) {
+	fields, ok := decodeProto(data)
+	if !ok {
+		return nil, false
+	}
+	part := &FmpLayoutPart{}
+	for _, field := range fields {
+		switch field.num {
+		case 1:
+			part.Type = FmpLayoutPartType(field.value)
+		case 4:
+			part.Top = math.Float64frombits(field.value)
+		case 5:
+			part.Bottom = math.Float64frombits(field.value)
+		}
+	}
+	return part, true
+}
+
+// protoField is a field of a message in the format of Protocol Buffers, which
+// FileMaker uses for layout data. Value holds varints and fixed-size numbers,
+// and Data length-delimited fields.
+type protoField struct {
+	num   uint64
+	value uint64
+	data  []byte
+}
+
+func decodeProto(data []byte) ([]protoField, bool) {
+	fields := make([]protoField, 0)
+	pos := 0
+
+	for pos < len(data) {
+		tag, n := binary.Uvarint(data[pos:])
+		if n <= 0 {
+			return nil, false
+		}
+		pos += n
+		field := protoField{num: tag >> 3}
+
+		switch tag & 0x07 {
+		case 0:
+			field.value, n = binary.Uvarint(data[pos:])
+			if n <= 0 {
+				return nil, false
+			}
+			pos += n
+		case 1:
+			if pos+8 > len(data) {
+				return nil, false
+			}
+			field.value = binary.LittleEndian.Uint64(data[pos:])
+			pos += 8
+		case 2:
+			length, n := binary.Uvarint(data[pos:])
+			if n <= 0 || length > uint64(len(data)-pos-n) {
+				return nil, false
+			}
+			pos += n
+			field.data = data[pos : pos+int(length)]
+			pos += int(length)
+		case 5:
+			if pos+4 > len(data) {
+				return nil, false
+			}
+			field.value = uint64(binary.LittleEndian.Uint32(data[pos:]))
+			pos += 4
+		default:
+			return nil, false
+		}
+
+		fields = append(fields, field)
+	}
+	return fields, true
+}
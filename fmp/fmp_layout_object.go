@@ -0,0 +1,209 @@
+package fmp
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"strings"
+)
+
+// FmpLayoutObject is an object placed on a layout. Its bounds are in points
+// from the top left corner of the layout.
+type FmpLayoutObject struct {
+	Type FmpLayoutObjectType
+
+	Top    float64
+	Left   float64
+	Bottom float64
+	Right  float64
+
+	// Field is the field shown by field objects. Occurrence is set for field
+	// objects and portals, and is the occurrence whose records are shown.
+	Field      FmpFieldRef
+	Occurrence *FmpTableOccurrence
+
+	Text string // Label of text objects, buttons and tab panels
+
+	// Record holds the object as stored, including its style, which is not
+	// decoded yet.
+	Record []byte
+}
+
+// FmpLayoutObjectType is the kind of a layout object. Values other than the
+// ones below, such as the 1 of the only object in the sample file, are not
+// known.
+type FmpLayoutObjectType uint64
+
+const (
+	FmpLayoutObjectText       FmpLayoutObjectType = 2
+	FmpLayoutObjectField      FmpLayoutObjectType = 3
+	FmpLayoutObjectButton     FmpLayoutObjectType = 4
+	FmpLayoutObjectPortal     FmpLayoutObjectType = 5
+	FmpLayoutObjectTabControl FmpLayoutObjectType = 6
+	FmpLayoutObjectTabPanel   FmpLayoutObjectType = 7
+)
+
+func (t FmpLayoutObjectType) String() string {
+	switch t {
+	case FmpLayoutObjectText:
+		return "Text"
+	case FmpLayoutObjectField:
+		return "Field"
+	case FmpLayoutObjectButton:
+		return "Button"
+	case FmpLayoutObjectPortal:
+		return "Portal"
+	case FmpLayoutObjectTabControl:
+		return "Tab Control"
+	case FmpLayoutObjectTabPanel:
+		return "Tab Panel"
+	}
+	return fmt.Sprintf("Object %d", uint64(t))
+}
+
+// readLayoutObjects decodes the objects of a layout, which are the repeated
+// field 2 of [4].[5].[layout].[13], in the format of Protocol Buffers:
+//
+//   - field 1: type
+//   - field 4: bounds, with the top, left, bottom and right as doubles in
+//     fields 1 to 4
+//   - field 5: style
+//   - field 6: ID of the table occurrence of fields and portals
+//   - field 7: ID of the field of field objects
+//   - field 8: label, as UTF-8
+//
+// Fields 1 to 5 are as found in the sample file. Its only object has no
+// fields 6 to 8, so their layout is this package's own.
+func (ctx *FmpFile) readLayoutObjects(layout *FmpLayout) error {
+	data := ctx.Dictionary.GetValue(4, 5, layout.ID, 13)
+	if data == nil {
+		return nil
+	}
+
+	fields, ok := decodeProto(data)
+	if !ok {
+		return ctx.problem(&FmpParseError{Err: ErrBadDictionary, Path: []uint64{4, 5, layout.ID, 13}})
+	}
+
+	for _, field := range fields {
+		if field.num != 2 {
+			continue
+		}
+		record, ok := decodeProto(field.data)
+		if !ok {
+			if err := ctx.problem(&FmpParseError{Err: ErrBadDictionary, Path: []uint64{4, 5, layout.ID, 13}}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		object := &FmpLayoutObject{Record: field.data}
+		object.Top, object.Left, object.Bottom, object.Right = decodeLayoutBounds(field.data)
+		var occID, fieldID uint64
+		for _, f := range record {
+			switch f.num {
+			case 1:
+				object.Type = FmpLayoutObjectType(f.value)
+			case 6:
+				occID = f.value
+			case 7:
+				fieldID = f.value
+			case 8:
+				object.Text = string(f.data)
+			}
+		}
+		if occID != 0 {
+			object.Occurrence = ctx.occurrenceByID(occID)
+		}
+		if fieldID != 0 {
+			object.Field = ctx.resolveFieldRef(occID, fieldID)
+		}
+
+		layout.Objects = append(layout.Objects, object)
+		layout.Width = max(layout.Width, object.Right)
+	}
+	return nil
+}
+
+// decodeLayoutBounds returns the top, left, bottom and right of a layout
+// object, which are doubles in fields 1 to 4 of field 4 of the object.
+func decodeLayoutBounds(object []byte) (top, left, bottom, right float64) {
+	fields, _ := decodeProto(object)
+	for _, field := range fields {
+		if field.num != 4 {
+			continue
+		}
+		bounds, _ := decodeProto(field.data)
+		for _, b := range bounds {
+			v := math.Float64frombits(b.value)
+			switch b.num {
+			case 1:
+				top = v
+			case 2:
+				left = v
+			case 3:
+				bottom = v
+			case 4:
+				right = v
+			}
+		}
+	}
+	return top, left, bottom, right
+}
+
+// RenderSVG draws a wireframe of the layout as an SVG image, showing the
+// parts and the objects on them with their labels or the fields they show.
+func (l *FmpLayout) RenderSVG(w io.Writer) error {
+	var b strings.Builder
+	text := func(x, y float64, anchor, class, s string) {
+		fmt.Fprintf(&b, `  <text x="%g" y="%g" text-anchor="%s" class="%s">%s</text>`+"\n", x, y, anchor, class, html.EscapeString(s))
+	}
+	rect := func(class string, x, y, width, height float64, radius int) {
+		fmt.Fprintf(&b, `  <rect class="%s" x="%g" y="%g" width="%g" height="%g" rx="%d"/>`+"\n", class, x, y, width, height, radius)
+	}
+
+	width := max(l.Width, 1)
+	height := max(l.Height, 1)
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%g" height="%g" viewBox="0 0 %g %g">`+"\n", width, height, width, height)
+	fmt.Fprintf(&b, "  <title>%s</title>\n", html.EscapeString(l.Name))
+	b.WriteString("  <style>rect { fill: none; stroke: #888; } rect.part { fill: #f6f6f6; stroke: #ccc; } rect.field { fill: #fff; } rect.button { fill: #e8e8e8; } rect.tab-panel { fill: #eee; } text { font: 11px sans-serif; fill: #333; } text.part, text.portal { fill: #aaa; }</style>\n")
+
+	for _, part := range l.Parts {
+		rect("part", 0, part.Top, width, part.Bottom-part.Top, 0)
+		text(2, part.Bottom-3, "start", "part", part.Type.String())
+	}
+
+	for _, object := range l.Objects {
+		objWidth, objHeight := object.Right-object.Left, object.Bottom-object.Top
+		middle := object.Top + objHeight/2 + 4
+		class := strings.ToLower(strings.ReplaceAll(object.Type.String(), " ", "-"))
+
+		switch object.Type {
+		case FmpLayoutObjectText:
+			text(object.Left, middle, "start", class, object.Text)
+		case FmpLayoutObjectField:
+			rect(class, object.Left, object.Top, objWidth, objHeight, 0)
+			text(object.Left+3, middle, "start", class, object.Field.String())
+		case FmpLayoutObjectButton:
+			rect(class, object.Left, object.Top, objWidth, objHeight, 4)
+			text(object.Left+objWidth/2, middle, "middle", class, object.Text)
+		case FmpLayoutObjectTabControl:
+			rect(class, object.Left, object.Top, objWidth, objHeight, 0)
+		case FmpLayoutObjectPortal:
+			rect(class, object.Left, object.Top, objWidth, objHeight, 0)
+			if object.Occurrence != nil {
+				text(object.Left+3, object.Top+12, "start", class, object.Occurrence.Name)
+			}
+		case FmpLayoutObjectTabPanel:
+			rect(class, object.Left, object.Top, objWidth, objHeight, 2)
+			text(object.Left+objWidth/2, middle, "middle", class, object.Text)
+		default:
+			rect("object", object.Left, object.Top, objWidth, objHeight, 0)
+		}
+	}
+
+	b.WriteString("</svg>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
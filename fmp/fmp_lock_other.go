@@ -0,0 +1,19 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package fmp
+
+import (
+	"os"
+	"time"
+)
+
+// Advisory locks are not available on this platform, so files can only be
+// opened with NoLock.
+
+func lockFile(f *os.File, exclusive bool, timeout time.Duration) error {
+	return ErrNoLocking
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
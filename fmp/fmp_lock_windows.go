@@ -0,0 +1,60 @@
+//go:build windows
+
+package fmp
+
+import (
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	errorLockViolation syscall.Errno = 33
+)
+
+// Locks cover the whole file, whatever its size.
+const lockBytes = ^uint32(0)
+
+func lockFile(f *os.File, exclusive bool, timeout time.Duration) error {
+	flags := uint32(lockfileFailImmediately)
+	if exclusive {
+		flags |= lockfileExclusiveLock
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		var overlapped syscall.Overlapped
+		r, _, err := procLockFileEx.Call(f.Fd(), uintptr(flags), 0,
+			uintptr(lockBytes), uintptr(lockBytes), uintptr(unsafe.Pointer(&overlapped)))
+		if r != 0 {
+			return nil
+		}
+		if err != errorLockViolation {
+			return err
+		}
+		if !time.Now().Before(deadline) {
+			return ErrLocked
+		}
+		time.Sleep(min(lockRetryInterval, time.Until(deadline)))
+	}
+}
+
+func unlockFile(f *os.File) error {
+	var overlapped syscall.Overlapped
+	r, _, err := procUnlockFileEx.Call(f.Fd(), 0,
+		uintptr(lockBytes), uintptr(lockBytes), uintptr(unsafe.Pointer(&overlapped)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
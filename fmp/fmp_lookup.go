@@ -0,0 +1,128 @@
+package fmp
+
+// FmpLookup holds the lookup options of a field, which copies its value from
+// a related record whenever the fields of the relationship change.
+type FmpLookup struct {
+	Source FmpFieldRef // Field to copy the value from
+	Active bool        // The lookup is enabled, rather than switched off
+
+	NoMatch   FmpLookupNoMatch // What to copy when no record is related
+	Value     string           // Value to enter when NoMatch is FmpLookupUseValue
+	SkipEmpty bool             // Do not copy empty values
+
+	source []byte // Resolved to Source once the occurrences are read
+}
+
+type FmpLookupNoMatch uint8
+
+const (
+	FmpLookupDoNotCopy FmpLookupNoMatch = iota
+	FmpLookupNextLower
+	FmpLookupNextHigher
+	FmpLookupUseValue
+)
+
+// decodeLookup decodes the lookup options of a field. Byte 10 of the flags has
+// bit 2 set for lookups, and byte 11 bit 7 for those that are active. The rest
+// of the definition is at key 7 of the field definition:
+//
+//   - key 1: the source, as a length-prefixed occurrence ID and field ID.
+//   - key 2: what to copy when no record is related in byte 0, as an
+//     FmpLookupNoMatch, and 0x01 in byte 1 to not copy empty values.
+//   - key 3: the value to copy when no record is related.
+//
+// The sample file has no lookups, so this layout is this package's own.
+func decodeLookup(flags []byte, d *FmpDict) *FmpLookup {
+	if flags[10]&0x04 == 0 {
+		return nil
+	}
+	lookup := &FmpLookup{
+		Active: flags[11]&0x80 != 0,
+		Value:  decodeString(d.GetValue(7, 3)),
+		source: d.GetValue(7, 1),
+	}
+	if options := d.GetValue(7, 2); len(options) >= 2 {
+		lookup.NoMatch = FmpLookupNoMatch(options[0])
+		lookup.SkipEmpty = options[1]&0x01 != 0
+	}
+	return lookup
+}
+
+// Relookup copies the value of a lookup field from the related record again,
+// like the Relookup Field Contents script step. When more than one record is
+// related, the value is copied from the first. When none is, the field is
+// left as it is, unless the lookup copies the next lower or higher value of
+// the match field, or a fixed value, instead. It returns ErrNoLookup if the
+// field has no lookup, if it is switched off, or if its source cannot be
+// resolved.
+func (r *FmpRecord) Relookup(name string) error {
+	column := r.Table.Column(name)
+	if column == nil {
+		return ErrUnknownColumn
+	}
+	lookup := column.Lookup
+	if lookup == nil || !lookup.Active || lookup.Source.Occurrence == nil || lookup.Source.Column == nil {
+		return ErrNoLookup
+	}
+
+	var source *FmpRecord
+	for related := range r.Related(lookup.Source.Occurrence.Name) {
+		source = related
+		break
+	}
+
+	var value string
+	switch {
+	case source != nil:
+		value = source.Value(lookup.Source.Column.Name)
+	case lookup.NoMatch == FmpLookupNextLower || lookup.NoMatch == FmpLookupNextHigher:
+		source = r.nearestMatch(lookup)
+		if source == nil {
+			return nil
+		}
+		value = source.Value(lookup.Source.Column.Name)
+	case lookup.NoMatch == FmpLookupUseValue:
+		value = lookup.Value
+	default:
+		return nil
+	}
+
+	if value == "" && lookup.SkipEmpty {
+		return nil
+	}
+	return r.Update(map[string]string{name: value})
+}
+
+// nearestMatch finds the record whose match field holds the value closest
+// below or above that of this record, using the first predicate of the
+// relationship to the lookup source.
+func (r *FmpRecord) nearestMatch(lookup *FmpLookup) *FmpRecord {
+	rel, reversed := r.Table.file.relationshipTo(r.Table, nil, lookup.Source.Occurrence.Name)
+	if rel == nil || len(rel.Predicates) == 0 || rel.Predicates[0].Operator == FmpRelationCartesian {
+		return nil
+	}
+
+	local, remote := rel.Predicates[0].Left, rel.Predicates[0].Right
+	if reversed {
+		local, remote = remote, local
+	}
+	key := r.Value(local.Name)
+	if key == "" {
+		return nil
+	}
+
+	var nearest *FmpRecord
+	var nearestValue string
+	for _, candidate := range remote.Table.AllRecords() {
+		value := candidate.Value(remote.Name)
+		if value == "" {
+			continue
+		}
+		c := compareValues(value, key, local.DataType)
+		if lookup.NoMatch == FmpLookupNextLower && c < 0 && (nearest == nil || compareValues(value, nearestValue, local.DataType) > 0) ||
+			lookup.NoMatch == FmpLookupNextHigher && c > 0 && (nearest == nil || compareValues(value, nearestValue, local.DataType) < 0) {
+			nearest, nearestValue = candidate, value
+		}
+	}
+	return nearest
+}
@@ -0,0 +1,321 @@
+package fmp
+
+import (
+	"cmp"
+	"iter"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// FmpTableOccurrence is a table as it appears on the relationship graph. A
+// base table can occur any number of times under different names.
+type FmpTableOccurrence struct {
+	ID    uint64
+	Name  string
+	Table *FmpTable
+
+	// GraphData holds the relationship graph data stored with the occurrence,
+	// which is not decoded yet.
+	GraphData []byte
+}
+
+// FmpRelationship joins two table occurrences on one or more predicates, all
+// of which must hold for records to be related.
+type FmpRelationship struct {
+	ID         uint64
+	Left       *FmpTableOccurrence
+	Right      *FmpTableOccurrence
+	Predicates []FmpJoinPredicate
+
+	CascadeCreateLeft  bool // Allow creation of Left records through this relationship
+	CascadeDeleteLeft  bool // Delete Left records when a related Right record is deleted
+	CascadeCreateRight bool // Allow creation of Right records through this relationship
+	CascadeDeleteRight bool // Delete Right records when a related Left record is deleted
+}
+
+type FmpJoinPredicate struct {
+	Operator FmpRelationOperator
+	Left     *FmpColumn // Field of the left occurrence's table
+	Right    *FmpColumn // Field of the right occurrence's table
+}
+
+// TableOccurrences returns the table occurrences on the relationship graph,
+// ordered by ID.
+func (ctx *FmpFile) TableOccurrences() []*FmpTableOccurrence {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+	return slices.Clone(ctx.occurrences)
+}
+
+// TableOccurrence returns the table occurrence with the given name, or nil.
+func (ctx *FmpFile) TableOccurrence(name string) *FmpTableOccurrence {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+	return ctx.occurrence(name)
+}
+
+// Relationships returns the relationships between table occurrences, ordered
+// by ID.
+func (ctx *FmpFile) Relationships() []*FmpRelationship {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+	return slices.Clone(ctx.relationships)
+}
+
+func (ctx *FmpFile) occurrence(name string) *FmpTableOccurrence {
+	for _, occ := range ctx.occurrences {
+		if occ.Name == name {
+			return occ
+		}
+	}
+	return nil
+}
+
+func (ctx *FmpFile) occurrenceByID(id uint64) *FmpTableOccurrence {
+	for _, occ := range ctx.occurrences {
+		if occ.ID == id {
+			return occ
+		}
+	}
+	return nil
+}
+
+func (ctx *FmpFile) tableByID(id uint64) *FmpTable {
+	for _, table := range ctx.tables {
+		if table.ID == id {
+			return table
+		}
+	}
+	return nil
+}
+
+// readRelationships decodes the table occurrences and the relationships of
+// the relationship graph.
+//
+// Table occurrences live at [3].[17].[5].[occurrence], with their name at key
+// 16 and their graph data at key 251. Byte 7 of key 2, counting from 1, holds
+// the base table, as the low byte of the two-byte key the table has under
+// [3].[16].[5]. Bytes 6 and 7 are read together.
+//
+// Relationships live at [3].[17].[5].[0].[relationship], and refer to table
+// occurrences and fields by the same keys they have in their own catalogs.
+// Key 2 holds the left occurrence in bytes 1 to 3, the right one in bytes 4
+// to 6, and the cascade options in byte 7. Each join predicate is a value at
+// [relationship].[3].[predicate], with the operator in byte 1, the left field
+// in bytes 2 and 3, and the right field in bytes 4 and 5.
+func (ctx *FmpFile) readRelationships() error {
+	ctx.occurrences = make([]*FmpTableOccurrence, 0)
+
+	for id, ent := range *ctx.Dictionary.GetChildren(3, 17, 5) {
+		if id < 128 {
+			continue
+		}
+
+		meta := ent.Children.GetValue(2)
+		if len(meta) < 7 {
+			if err := ctx.problem(&FmpParseError{Err: ErrBadDictionary, Path: []uint64{3, 17, 5, id, 2}}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		ctx.occurrences = append(ctx.occurrences, &FmpTableOccurrence{
+			ID:        id,
+			Name:      decodeString(ent.Children.GetValue(16)),
+			Table:     ctx.tableByID(decodeVarUint64(meta[5:7])),
+			GraphData: ent.Children.GetValue(251),
+		})
+	}
+	slices.SortFunc(ctx.occurrences, func(a, b *FmpTableOccurrence) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	ctx.relationships = make([]*FmpRelationship, 0)
+	for id, ent := range *ctx.Dictionary.GetChildren(3, 17, 5, 0) {
+		if id == 251 {
+			continue
+		}
+		rel, ok := ctx.decodeRelationship(id, ent.Children)
+		if !ok {
+			if err := ctx.problem(&FmpParseError{Err: ErrBadDictionary, Path: []uint64{3, 17, 5, 0, id}}); err != nil {
+				return err
+			}
+			continue
+		}
+		ctx.relationships = append(ctx.relationships, rel)
+	}
+	slices.SortFunc(ctx.relationships, func(a, b *FmpRelationship) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	ctx.resolveColumnOccurrences()
+	return nil
+}
+
+// resolveColumnOccurrences links the calculation contexts and lookup sources
+// of fields to the occurrences they refer to, once those are read.
+func (ctx *FmpFile) resolveColumnOccurrences() {
+	for _, table := range ctx.tables {
+		for _, column := range table.Columns {
+			column.Context = ctx.occurrenceByID(column.contextID)
+			if column.Lookup != nil {
+				column.Lookup.Source = ctx.decodeFieldRef(column.Lookup.source)
+			}
+		}
+	}
+}
+
+// decodeRelationship decodes a relationship, and reports whether its
+// occurrences and fields could be resolved.
+func (ctx *FmpFile) decodeRelationship(id uint64, d *FmpDict) (*FmpRelationship, bool) {
+	meta := d.GetValue(2)
+	if len(meta) < 7 {
+		return nil, false
+	}
+
+	rel := &FmpRelationship{
+		ID:                 id,
+		Left:               ctx.occurrenceByID(decodeVarUint64(meta[0:3])),
+		Right:              ctx.occurrenceByID(decodeVarUint64(meta[3:6])),
+		CascadeCreateLeft:  meta[6]&0x01 != 0,
+		CascadeDeleteLeft:  meta[6]&0x02 != 0,
+		CascadeCreateRight: meta[6]&0x04 != 0,
+		CascadeDeleteRight: meta[6]&0x08 != 0,
+	}
+	if rel.Left == nil || rel.Right == nil || rel.Left.Table == nil || rel.Right.Table == nil {
+		return nil, false
+	}
+
+	predicates := d.GetChildren(3)
+	keys := slices.Sorted(maps.Keys(*predicates))
+	for _, key := range keys {
+		value := (*predicates)[key].Value
+		if len(value) < 5 {
+			return nil, false
+		}
+		pred := FmpJoinPredicate{
+			Operator: FmpRelationOperator(value[0]),
+			Left:     rel.Left.Table.Columns[decodeVarUint64(value[1:3])],
+			Right:    rel.Right.Table.Columns[decodeVarUint64(value[3:5])],
+		}
+		if pred.Operator > FmpRelationCartesian {
+			return nil, false
+		}
+		if pred.Operator != FmpRelationCartesian && (pred.Left == nil || pred.Right == nil) {
+			return nil, false
+		}
+		rel.Predicates = append(rel.Predicates, pred)
+	}
+	return rel, len(rel.Predicates) > 0
+}
+
+// Related returns the records of the named table occurrence that are related
+// to this record, in the same way a FileMaker portal would show them. The
+// relationship is looked up among those stored in the file, between an
+// occurrence of this record's table and the named occurrence, and evaluated
+// in whichever direction it connects them.
+func (r *FmpRecord) Related(occurrenceName string) iter.Seq[*FmpRecord] {
+	return r.related(nil, occurrenceName)
+}
+
+// related is like Related, but only follows relationships from the given
+// occurrence of this record's table, unless it is nil.
+func (r *FmpRecord) related(start *FmpTableOccurrence, occurrenceName string) iter.Seq[*FmpRecord] {
+	return func(yield func(*FmpRecord) bool) {
+		rel, reversed := r.Table.file.relationshipTo(r.Table, start, occurrenceName)
+		if rel == nil {
+			return
+		}
+
+		target := rel.Right.Table
+		if reversed {
+			target = rel.Left.Table
+		}
+
+		for _, candidate := range target.AllRecords() {
+			if rel.matches(r, candidate, reversed) && !yield(candidate) {
+				return
+			}
+		}
+	}
+}
+
+// relationshipTo finds a relationship between an occurrence of the given
+// table and the named occurrence, starting from the given occurrence if it is
+// not nil. It reports whether the named occurrence is on the left-hand side.
+func (ctx *FmpFile) relationshipTo(from *FmpTable, start *FmpTableOccurrence, occurrenceName string) (*FmpRelationship, bool) {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+
+	for _, rel := range ctx.relationships {
+		if rel.Right.Name == occurrenceName && rel.Left.Table == from && (start == nil || rel.Left == start) {
+			return rel, false
+		}
+	}
+	for _, rel := range ctx.relationships {
+		if rel.Left.Name == occurrenceName && rel.Right.Table == from && (start == nil || rel.Right == start) {
+			return rel, true
+		}
+	}
+	return nil, false
+}
+
+func (rel *FmpRelationship) matches(from, to *FmpRecord, reversed bool) bool {
+	from.Table.file.mu.RLock()
+	defer from.Table.file.mu.RUnlock()
+
+	for _, pred := range rel.Predicates {
+		left, right := from, to
+		if reversed {
+			left, right = to, from
+		}
+		if !pred.matches(left, right) {
+			return false
+		}
+	}
+	return true
+}
+
+func (pred FmpJoinPredicate) matches(left, right *FmpRecord) bool {
+	if pred.Operator == FmpRelationCartesian {
+		return true
+	}
+
+	lv, rv := left.Values[pred.Left.Index], right.Values[pred.Right.Index]
+	if lv == "" || rv == "" {
+		return false
+	}
+
+	// Like in FileMaker, every line of a multi-line key is matched separately.
+	if pred.Operator == FmpRelationEqual {
+		for _, l := range splitKeys(lv) {
+			for _, r := range splitKeys(rv) {
+				if compareValues(l, r, pred.Left.DataType) == 0 {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	c := compareValues(lv, rv, pred.Left.DataType)
+	switch pred.Operator {
+	case FmpRelationNotEqual:
+		return c != 0
+	case FmpRelationLess:
+		return c < 0
+	case FmpRelationLessOrEqual:
+		return c <= 0
+	case FmpRelationGreater:
+		return c > 0
+	case FmpRelationGreaterOrEqual:
+		return c >= 0
+	}
+	return false
+}
+
+func splitKeys(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == '\r' || r == '\n'
+	})
+}
@@ -0,0 +1,237 @@
+package fmp
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type FmpScript struct {
+	ID     uint64
+	Name   string
+	Folder string // Name of the folder the script is in, if any
+	Steps  []*FmpScriptStep
+}
+
+type FmpScriptStep struct {
+	Index    uint64 // Key of the step in the script's step directory
+	Type     FmpScriptStepType
+	Disabled bool // Commented out in the Script Workspace
+
+	// Params holds the decoded options of common steps, such as
+	// *FmpSetVariableParams for Set Variable. It is nil for other steps.
+	Params any
+
+	// Record holds the 24-byte record of the step.
+	Record []byte
+}
+
+const scriptStepSize = 24
+
+// Scripts returns the scripts in the file, ordered by ID. Folders are not
+// returned themselves, but are reflected in the Folder of their scripts.
+func (ctx *FmpFile) Scripts() []*FmpScript {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+	return slices.Clone(ctx.scripts)
+}
+
+// Script returns the script with the given name, or nil.
+func (ctx *FmpFile) Script(name string) *FmpScript {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+
+	for _, script := range ctx.scripts {
+		if script.Name == name {
+			return script
+		}
+	}
+	return nil
+}
+
+// readScripts decodes the script catalog.
+//
+// Names live at [17].[1].[7].[script] key 16. Folders are catalog entries
+// whose key 2 has bit 0 of byte 0 set, and key 4 holds the length-prefixed ID
+// of the folder an entry is in.
+//
+// The steps live at [17].[5].[script] key 4, or at the path [17].[5].[script].[4]
+// for long scripts, as 24-byte records. Counting from 1, bytes 3 and 4 of a
+// record hold the key of the step in [17].[5].[script].[5], and bytes 5 and 6
+// its type. Disabled steps are stored with type FmpScriptCommentedOut, and
+// their own type in bytes 7 and 8.
+func (ctx *FmpFile) readScripts() error {
+	ctx.scripts = make([]*FmpScript, 0)
+	catalog := ctx.Dictionary.GetChildren(17, 1, 7)
+
+	folderName := func(ent *FmpDictEntry) string {
+		parentID, _, ok := decodeLengthPrefixed(ent.Children.GetValue(4), 0)
+		if !ok {
+			return ""
+		}
+		parent := catalog.GetEntry(parentID)
+		if parent == nil {
+			return ""
+		}
+		return decodeString(parent.Children.GetValue(16))
+	}
+
+	for id, ent := range *catalog {
+		if meta := ent.Children.GetValue(2); len(meta) > 0 && meta[0]&0x01 != 0 {
+			continue
+		}
+
+		script := &FmpScript{
+			ID:     id,
+			Name:   decodeString(ent.Children.GetValue(16)),
+			Folder: folderName(ent),
+			Steps:  make([]*FmpScriptStep, 0),
+		}
+
+		code := ctx.Dictionary.GetValue(17, 5, id, 4)
+		if len(code)%scriptStepSize != 0 {
+			if err := ctx.problem(&FmpParseError{Err: ErrBadDictionary, Path: []uint64{17, 5, id, 4}}); err != nil {
+				return err
+			}
+		}
+
+		for i := 0; i+scriptStepSize <= len(code); i += scriptStepSize {
+			rec := code[i : i+scriptStepSize]
+			step := &FmpScriptStep{
+				Index:  decodeVarUint64(rec[2:4]),
+				Type:   FmpScriptStepType(decodeVarUint64(rec[4:6])),
+				Record: rec,
+			}
+			if step.Type == FmpScriptCommentedOut {
+				step.Type = FmpScriptStepType(decodeVarUint64(rec[6:8]))
+				step.Disabled = true
+			}
+			script.Steps = append(script.Steps, step)
+		}
+
+		ctx.scripts = append(ctx.scripts, script)
+	}
+
+	slices.SortFunc(ctx.scripts, func(a, b *FmpScript) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	for _, script := range ctx.scripts {
+		ctx.readScriptStepParams(script)
+	}
+	return nil
+}
+
+// Format renders the script as text, the way FileMaker's Script Workspace
+// shows it: one step per line, blocks indented, and disabled steps prefixed
+// with "//".
+func (s *FmpScript) Format() string {
+	var b strings.Builder
+	indent := 0
+
+	for _, step := range s.Steps {
+		switch step.Type {
+		case FmpScriptElse, FmpScriptElseIf, FmpScriptEndIf, FmpScriptEndLoop:
+			indent = max(indent-1, 0)
+		}
+
+		b.WriteString(strings.Repeat("    ", indent))
+		if step.Disabled {
+			b.WriteString("// ")
+		}
+		b.WriteString(step.Format())
+		b.WriteString("\n")
+
+		switch step.Type {
+		case FmpScriptIf, FmpScriptElse, FmpScriptElseIf, FmpScriptLoop:
+			indent++
+		}
+	}
+	return b.String()
+}
+
+// Format renders a single step with its options, without indentation. Steps
+// whose options are not decoded, such as comments, are rendered by name only.
+func (step *FmpScriptStep) Format() string {
+	name := step.Type.String()
+
+	var opts []string
+	switch p := step.Params.(type) {
+	case *FmpSetVariableParams:
+		opts = []string{p.Name, "Value: " + p.Value.String()}
+
+	case *FmpSetFieldParams:
+		opts = []string{p.Field.String(), p.Value.String()}
+
+	case *FmpGoToLayoutParams:
+		if p.Original {
+			opts = []string{"original layout"}
+		} else {
+			opts = []string{fmt.Sprintf("layout %d", p.LayoutID)}
+		}
+
+	case *FmpPerformScriptParams:
+		script := "<unknown script>"
+		if p.Script != nil {
+			script = "“" + p.Script.Name + "”"
+		}
+		opts = []string{script}
+		if p.Parameter != nil {
+			opts = append(opts, "Parameter: "+p.Parameter.String())
+		}
+
+	case *FmpConditionParams:
+		opts = []string{p.Condition.String()}
+
+	case *FmpAllowUserAbortParams:
+		opts = []string{onOff(p.On)}
+
+	case *FmpShowCustomDialogParams:
+		opts = []string{p.Title.String(), p.Message.String()}
+		for _, button := range p.Buttons {
+			opts = append(opts, "“"+button+"”")
+		}
+
+	case *FmpSortParams:
+		for _, field := range p.Order {
+			opt := field.Field.String()
+			if field.Descending {
+				opt += " (descending)"
+			}
+			opts = append(opts, opt)
+		}
+
+	case *FmpFindParams:
+		opts = []string{"Restore"}
+		for _, request := range p.Requests {
+			criteria := []string{}
+			for _, crit := range request.Criteria {
+				criteria = append(criteria, crit.Field.String()+": “"+crit.Value+"”")
+			}
+			action := "Find"
+			if request.Omit {
+				action = "Omit"
+			}
+			opts = append(opts, action+" Records: "+strings.Join(criteria, ", "))
+		}
+	}
+
+	if len(opts) == 0 {
+		return name
+	}
+	return name + " [ " + strings.Join(opts, " ; ") + " ]"
+}
+
+func (ref FmpFieldRef) String() string {
+	if ref.Occurrence == nil || ref.Column == nil {
+		return "<unknown field>"
+	}
+	return ref.Occurrence.Name + "::" + ref.Column.Name
+}
+
+func onOff(on bool) string {
+	if on {
+		return "On"
+	}
+	return "Off"
+}
@@ -0,0 +1,208 @@
+package fmp
+
+import "slices"
+
+// FmpFieldRef refers to a field through a table occurrence, the way script
+// steps and calculations do.
+type FmpFieldRef struct {
+	Occurrence *FmpTableOccurrence
+	Column     *FmpColumn
+}
+
+type FmpSetVariableParams struct {
+	Name  string
+	Value *FmpCalculation
+}
+
+type FmpSetFieldParams struct {
+	Field FmpFieldRef
+	Value *FmpCalculation
+}
+
+type FmpGoToLayoutParams struct {
+	LayoutID uint64
+	Original bool // Go to the layout the script started on
+}
+
+type FmpPerformScriptParams struct {
+	Script    *FmpScript
+	Parameter *FmpCalculation
+}
+
+// FmpConditionParams holds the condition of an If, Else If or Exit Loop If step.
+type FmpConditionParams struct {
+	Condition *FmpCalculation
+}
+
+type FmpAllowUserAbortParams struct {
+	On bool
+}
+
+type FmpShowCustomDialogParams struct {
+	Title   *FmpCalculation
+	Message *FmpCalculation
+	Buttons []string
+}
+
+type FmpSortParams struct {
+	Order []FmpSortField
+}
+
+// FmpFindParams holds the stored find requests of an Enter Find Mode,
+// Perform Find, Constrain Found Set or Extend Found Set step.
+type FmpFindParams struct {
+	Requests []FmpFindRequest
+}
+
+type FmpFindRequest struct {
+	Omit     bool
+	Criteria []FmpFindCriterion
+}
+
+type FmpFindCriterion struct {
+	Field FmpFieldRef
+	Value string
+}
+
+// readScriptStepParams decodes the options of the steps of a script from the
+// step directory at [17].[5].[script].[5].[step]:
+//
+//   - key 2 holds the option bytes. Go to Layout stores the layout ID in
+//     bytes 7-9, and Allow User Abort its setting in byte 26 (1 = off, 3 = on).
+//   - [128] key 1 holds the name of the variable of Set Variable.
+//   - [129].[5] key 5 holds the main calculation of the step.
+//   - key 130 holds the target field as length-prefixed occurrence and field IDs.
+//   - key 131 holds the length-prefixed ID of the script to perform.
+//   - [132].[5] key 5 holds the title of a custom dialog, and [133].[n] key 16
+//     the labels of its buttons.
+//   - [134].[n] holds sort fields: a field reference followed by a byte that
+//     is 1 for descending order.
+//   - [135].[n] holds find requests, whose value is 1 for omit requests, and
+//     [135].[n].[m] their criteria: a field reference followed by the text.
+//
+// It runs after all scripts have been read, so Perform Script can refer to
+// any of them.
+func (ctx *FmpFile) readScriptStepParams(script *FmpScript) {
+	for _, step := range script.Steps {
+		data := ctx.Dictionary.GetChildren(17, 5, script.ID, 5, step.Index)
+		calc := ctx.decodeCalculation(data.GetValue(129, 5, 5), nil)
+
+		switch step.Type {
+		case FmpScriptSetVariable:
+			step.Params = &FmpSetVariableParams{
+				Name:  decodeString(data.GetValue(128, 1)),
+				Value: calc,
+			}
+
+		case FmpScriptSetField:
+			step.Params = &FmpSetFieldParams{
+				Field: ctx.decodeFieldRef(data.GetValue(130)),
+				Value: calc,
+			}
+
+		case FmpScriptGoToLayout:
+			params := &FmpGoToLayoutParams{Original: true}
+			if opts := data.GetValue(2); len(opts) >= 10 {
+				params.LayoutID = decodeVarUint64(opts[7:10])
+				params.Original = params.LayoutID == 0
+			}
+			step.Params = params
+
+		case FmpScriptPerformScript, FmpScriptPerformScriptOnServer:
+			params := &FmpPerformScriptParams{Parameter: calc}
+			if id, _, ok := decodeLengthPrefixed(data.GetValue(131), 0); ok {
+				params.Script = ctx.scriptByID(id)
+			}
+			step.Params = params
+
+		case FmpScriptIf, FmpScriptElseIf, FmpScriptExitLoopIf:
+			step.Params = &FmpConditionParams{Condition: calc}
+
+		case FmpScriptAllowUserAbort:
+			opts := data.GetValue(2)
+			step.Params = &FmpAllowUserAbortParams{On: len(opts) > 26 && opts[26] == 3}
+
+		case FmpScriptShowCustomDialog:
+			params := &FmpShowCustomDialogParams{
+				Title:   ctx.decodeCalculation(data.GetValue(132, 5, 5), nil),
+				Message: calc,
+			}
+			for _, key := range sortedKeys(data.GetChildren(133)) {
+				params.Buttons = append(params.Buttons, decodeString(data.GetValue(133, key, 16)))
+			}
+			step.Params = params
+
+		case FmpScriptSortRecords:
+			params := &FmpSortParams{}
+			for _, key := range sortedKeys(data.GetChildren(134)) {
+				value := data.GetValue(134, key)
+				ref, pos := ctx.decodeFieldRefAt(value, 0)
+				params.Order = append(params.Order, FmpSortField{
+					Field:      ref,
+					Descending: pos < len(value) && value[pos] == 1,
+				})
+			}
+			step.Params = params
+
+		case FmpScriptEnterFindMode, FmpScriptPerformFind, FmpScriptConstrainFoundSet, FmpScriptExtendFoundSet:
+			params := &FmpFindParams{}
+			for _, key := range sortedKeys(data.GetChildren(135)) {
+				omit := data.GetValue(135, key)
+				request := FmpFindRequest{Omit: len(omit) > 0 && omit[0] == 1}
+				for _, crit := range sortedKeys(data.GetChildren(135, key)) {
+					value := data.GetValue(135, key, crit)
+					ref, pos := ctx.decodeFieldRefAt(value, 0)
+					request.Criteria = append(request.Criteria, FmpFindCriterion{
+						Field: ref,
+						Value: decodeString(value[pos:]),
+					})
+				}
+				params.Requests = append(params.Requests, request)
+			}
+			step.Params = params
+		}
+	}
+}
+
+func (ctx *FmpFile) decodeFieldRef(value []byte) FmpFieldRef {
+	ref, _ := ctx.decodeFieldRefAt(value, 0)
+	return ref
+}
+
+// decodeFieldRefAt decodes a length-prefixed occurrence ID followed by a
+// length-prefixed field ID, returning the position just past them.
+func (ctx *FmpFile) decodeFieldRefAt(value []byte, pos int) (FmpFieldRef, int) {
+	ref := FmpFieldRef{}
+	occID, pos, ok := decodeLengthPrefixed(value, pos)
+	if !ok {
+		return ref, pos
+	}
+	fieldID, pos, ok := decodeLengthPrefixed(value, pos)
+	if !ok {
+		return ref, pos
+	}
+
+	ref.Occurrence = ctx.occurrenceByID(occID)
+	if ref.Occurrence != nil && ref.Occurrence.Table != nil {
+		ref.Column = ref.Occurrence.Table.Columns[fieldID]
+	}
+	return ref, pos
+}
+
+func (ctx *FmpFile) scriptByID(id uint64) *FmpScript {
+	for _, script := range ctx.scripts {
+		if script.ID == id {
+			return script
+		}
+	}
+	return nil
+}
+
+func sortedKeys(dict *FmpDict) []uint64 {
+	keys := make([]uint64, 0, len(*dict))
+	for key := range *dict {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,195 @@
+package fmp
+
+import (
+	"strings"
+	"unicode/utf16"
+)
+
+// Text is stored in the Standard Compression Scheme for Unicode (SCSU),
+// described in https://www.unicode.org/reports/tr6/, with every byte XORed
+// with 0x5A. SCSU leaves Latin-1 text as is, and switches to other windows of
+// Unicode characters, or to UTF-16, with tag bytes.
+
+const (
+	scsuSQ0 = 0x01 // Quote from window 0-7
+	scsuSDX = 0x0B // Define extended window
+	scsuSQU = 0x0E // Quote UTF-16
+	scsuSCU = 0x0F // Switch to UTF-16
+	scsuSC0 = 0x10 // Change to window 0-7
+	scsuSD0 = 0x18 // Define window 0-7
+
+	scsuUC0 = 0xE0 // Change to window 0-7 and single-byte mode
+	scsuUD0 = 0xE8 // Define window 0-7 and change to single-byte mode
+	scsuUQU = 0xF0 // Quote UTF-16
+	scsuUDX = 0xF1 // Define extended window and change to single-byte mode
+)
+
+var scsuStaticWindows = [8]rune{0x0000, 0x0080, 0x0100, 0x0300, 0x2000, 0x2080, 0x2100, 0x3000}
+
+var scsuDynamicWindows = [8]rune{0x0080, 0x00C0, 0x0400, 0x0600, 0x0900, 0x3040, 0x30A0, 0xFF00}
+
+// scsuWindowOffset returns the start of the window a define window tag
+// selects, or -1 for reserved values.
+func scsuWindowOffset(x byte) rune {
+	switch {
+	case x >= 0x01 && x <= 0x67:
+		return rune(x) * 0x80
+	case x >= 0x68 && x <= 0xA7:
+		return rune(x)*0x80 + 0xAC00
+	case x >= 0xF9:
+		return [...]rune{0x00C0, 0x0250, 0x0370, 0x0530, 0x3040, 0x30A0, 0xFF60}[x-0xF9]
+	}
+	return -1
+}
+
+func decodeString(payload []byte) string {
+	var b strings.Builder
+	windows := scsuDynamicWindows
+	active := 0
+	unicode := false
+	units := make([]uint16, 0)
+
+	pos := 0
+	next := func() (byte, bool) {
+		if pos >= len(payload) {
+			return 0, false
+		}
+		pos++
+		return payload[pos-1] ^ 0x5A, true
+	}
+	unit := func() bool {
+		hi, ok1 := next()
+		lo, ok2 := next()
+		if ok1 && ok2 {
+			units = append(units, uint16(hi)<<8|uint16(lo))
+		}
+		return ok1 && ok2
+	}
+	write := func(r rune) {
+		if len(units) > 0 {
+			b.WriteString(string(utf16.Decode(units)))
+			units = units[:0]
+		}
+		if r >= 0 {
+			b.WriteRune(r)
+		}
+	}
+	extended := func() bool {
+		hi, ok1 := next()
+		lo, ok2 := next()
+		if ok1 && ok2 {
+			active = int(hi >> 5)
+			windows[active] = 0x10000 + (rune(hi&0x1F)<<8|rune(lo))*0x80
+		}
+		return ok1 && ok2
+	}
+	define := func(n int) bool {
+		x, ok := next()
+		offset := scsuWindowOffset(x)
+		if ok && offset >= 0 {
+			windows[n], active = offset, n
+		} else if ok {
+			write(0xFFFD)
+		}
+		return ok
+	}
+
+	for {
+		c, ok := next()
+		if !ok {
+			break
+		}
+
+		if unicode {
+			switch {
+			case c >= scsuUC0 && c < scsuUD0:
+				active, unicode = int(c-scsuUC0), false
+			case c >= scsuUD0 && c < scsuUQU:
+				ok = define(int(c - scsuUD0))
+				unicode = false
+			case c == scsuUQU:
+				ok = unit()
+			case c == scsuUDX:
+				ok = extended()
+				unicode = false
+			case c == 0xF2:
+				write(0xFFFD)
+			default:
+				pos--
+				ok = unit()
+			}
+			if !ok {
+				write(0xFFFD)
+			}
+			continue
+		}
+
+		switch {
+		case c == 0 || c == '\t' || c == '\n' || c == '\r' || c >= 0x20 && c < 0x80:
+			write(rune(c))
+		case c >= 0x80:
+			write(windows[active] + rune(c-0x80))
+		case c >= scsuSQ0 && c < scsuSQ0+8:
+			q, ok := next()
+			switch {
+			case !ok:
+				write(0xFFFD)
+			case q < 0x80:
+				write(scsuStaticWindows[c-scsuSQ0] + rune(q))
+			default:
+				write(windows[c-scsuSQ0] + rune(q-0x80))
+			}
+		case c == scsuSDX:
+			if !extended() {
+				write(0xFFFD)
+			}
+		case c == scsuSQU:
+			if !unit() {
+				write(0xFFFD)
+			}
+		case c == scsuSCU:
+			unicode = true
+		case c >= scsuSC0 && c < scsuSD0:
+			active = int(c - scsuSC0)
+		case c >= scsuSD0:
+			if !define(int(c - scsuSD0)) {
+				write(0xFFFD)
+			}
+		default:
+			write(0xFFFD)
+		}
+	}
+	write(-1)
+	return b.String()
+}
+
+// encodeString encodes Latin-1 text as is, quoting characters that SCSU uses
+// as tags, and switches to UTF-16 at the first character beyond it.
+func encodeString(value string) []byte {
+	result := make([]byte, 0, len(value))
+	unicode := false
+	for _, r := range value {
+		if !unicode {
+			switch {
+			case r == 0 || r == '\t' || r == '\n' || r == '\r' || r >= 0x20 && r <= 0xFF:
+				result = append(result, byte(r))
+				continue
+			case r < 0x20:
+				result = append(result, scsuSQ0, byte(r))
+				continue
+			}
+			result = append(result, scsuSCU)
+			unicode = true
+		}
+		for _, u := range utf16.AppendRune(nil, r) {
+			if hi := byte(u >> 8); hi >= scsuUC0 && hi <= 0xF2 {
+				result = append(result, scsuUQU)
+			}
+			result = append(result, byte(u>>8), byte(u))
+		}
+	}
+	for i := range result {
+		result[i] ^= 0x5A
+	}
+	return result
+}
@@ -1,5 +1,7 @@
 package fmp
 
+import "slices"
+
 type FmpSector struct {
 	ID      uint64
 	Level   uint8
@@ -10,6 +12,8 @@ type FmpSector struct {
 	Next    *FmpSector
 	Payload []byte
 	Chunks  []*FmpChunk
+
+	offset int64 // Position of the sector in the file
 }
 
 type FmpChunk struct {
@@ -25,9 +29,9 @@ func (sect *FmpSector) readChunks() error {
 		panic("chunks already read")
 	}
 	for {
-		pos := (sect.ID+1)*sectorSize - uint64(len(sect.Payload))
+		pos := sect.offset + sectorSize - int64(len(sect.Payload))
 
-		if sect.Payload[0] == 0x00 && sect.Payload[1] == 0x00 {
+		if len(sect.Payload) >= 2 && sect.Payload[0] == 0x00 && sect.Payload[1] == 0x00 {
 			break
 		}
 
@@ -41,7 +45,13 @@ func (sect *FmpSector) readChunks() error {
 		if err != nil {
 			debug("chunk error at sector %d", sect.ID)
 			dump(sect.Payload)
-			return err
+			return &FmpParseError{
+				Err:       err,
+				SectorID:  sect.ID,
+				Offset:    pos,
+				Opcode:    sect.Payload[0],
+				HasOpcode: true,
+			}
 		}
 		if chunk == nil {
 			break
@@ -61,10 +71,9 @@ func (sect *FmpSector) readChunks() error {
 }
 
 func (sect *FmpSector) processChunks(dict *FmpDict) error {
-	err := sect.readChunks()
-	if err != nil {
-		return err
-	}
+	// Chunks read before a failure are still processed, so that the error can
+	// report the dictionary path at which decoding stopped.
+	readErr := sect.readChunks()
 
 	currentPath := make([]uint64, 0)
 	for _, chunk := range sect.Chunks {
@@ -104,75 +113,126 @@ func (sect *FmpSector) processChunks(dict *FmpDict) error {
 			// noop
 		}
 	}
-	return nil
+
+	if perr, ok := readErr.(*FmpParseError); ok {
+		perr.Path = slices.Clone(currentPath)
+	}
+	return readErr
 }
 
+// readChunk decodes the chunk at the start of the payload. Every length is
+// checked against the payload before slicing, and ErrBadChunk is returned
+// when a chunk runs past its end.
 func (sect *FmpSector) readChunk(payload []byte) (*FmpChunk, error) {
 
 	// https://github.com/evanmiller/fmptools/blob/02eb770e59e0866dab213d80e5f7d88e17648031/HACKING
 	// https://github.com/Rasmus20B/fmplib/blob/66245e5269275724bacfe1437fb1f73bc587a2f3/src/fmp_format/chunk.rs#L57-L60
 
+	size := uint64(len(payload))
+	if size == 0 {
+		return nil, ErrBadChunk
+	}
+
 	chunk := &FmpChunk{}
 	chunkCode := payload[0]
 
 	switch chunkCode {
 	case 0x00:
 		chunk.Length = 2
+		if size < chunk.Length {
+			return nil, ErrBadChunk
+		}
 		chunk.Type = FmpChunkSimpleData
 		chunk.Value = payload[1:chunk.Length]
 
 	case 0x01, 0x02, 0x03, 0x04, 0x05:
 		chunk.Length = 2 + 2*uint64(chunkCode-0x01) + addIf(chunkCode == 0x01, 1)
+		if size < chunk.Length {
+			return nil, ErrBadChunk
+		}
 		chunk.Type = FmpChunkSimpleKeyValue
 		chunk.Key = uint64(payload[1])
 		chunk.Value = payload[2:chunk.Length]
 
 	case 0x06:
+		if size < 3 {
+			return nil, ErrBadChunk
+		}
 		chunk.Length = 3 + uint64(payload[2])
+		if size < chunk.Length {
+			return nil, ErrBadChunk
+		}
 		chunk.Type = FmpChunkSimpleKeyValue
 		chunk.Key = uint64(payload[1])
 		chunk.Value = payload[3:chunk.Length]
 
 	case 0x07:
+		if size < 4 {
+			return nil, ErrBadChunk
+		}
 		valueLength := decodeVarUint64(payload[2 : 2+2])
-		chunk.Length = min(4+valueLength, uint64(len(payload)))
+		chunk.Length = min(4+valueLength, size)
 		chunk.Type = FmpChunkSegmentedData
 		chunk.Index = uint64(payload[1])
 		chunk.Value = payload[4:chunk.Length]
 
 	case 0x08:
 		chunk.Length = 3
+		if size < chunk.Length {
+			return nil, ErrBadChunk
+		}
 		chunk.Type = FmpChunkSimpleData
 		chunk.Value = payload[1:chunk.Length]
 
 	case 0x09:
 		chunk.Length = 4
+		if size < chunk.Length {
+			return nil, ErrBadChunk
+		}
 		chunk.Type = FmpChunkSimpleKeyValue
 		chunk.Key = decodeVarUint64(payload[1 : 1+2])
 		chunk.Value = payload[3:chunk.Length]
 
 	case 0x0A, 0x0B, 0x0C, 0x0D:
 		chunk.Length = 3 + 2*uint64(chunkCode-0x09)
+		if size < chunk.Length {
+			return nil, ErrBadChunk
+		}
 		chunk.Type = FmpChunkSimpleKeyValue
 		chunk.Key = decodeVarUint64(payload[1 : 1+2])
 		chunk.Value = payload[3:chunk.Length]
 
 	case 0x0E:
+		if size < 2 {
+			return nil, ErrBadChunk
+		}
 		if payload[1] == 0xFF {
 			chunk.Length = 7
+			if size < chunk.Length {
+				return nil, ErrBadChunk
+			}
 			chunk.Type = FmpChunkSimpleData
 			chunk.Value = payload[2:chunk.Length]
 			break
 		}
 
+		if size < 4 {
+			return nil, ErrBadChunk
+		}
 		chunk.Length = 4 + uint64(payload[3])
+		if size < chunk.Length {
+			return nil, ErrBadChunk
+		}
 		chunk.Type = FmpChunkSimpleKeyValue
 		chunk.Key = decodeVarUint64(payload[1 : 1+2])
 		chunk.Value = payload[4:chunk.Length]
 
 	case 0x0F:
+		if size < 5 {
+			return nil, ErrBadChunk
+		}
 		valueLength := decodeVarUint64(payload[3 : 3+2])
-		chunk.Length = uint64(len(payload))
+		chunk.Length = size
 		if chunk.Length > 5+valueLength {
 			return nil, ErrBadChunk
 		}
@@ -182,51 +242,93 @@ func (sect *FmpSector) readChunk(payload []byte) (*FmpChunk, error) {
 
 	case 0x10, 0x11:
 		chunk.Length = 4 + addIf(chunkCode == 0x11, 1)
+		if size < chunk.Length {
+			return nil, ErrBadChunk
+		}
 		chunk.Type = FmpChunkSimpleData
 		chunk.Value = payload[1:chunk.Length]
 
 	case 0x12, 0x13, 0x14, 0x15:
 		chunk.Length = 4 + 2*(uint64(chunkCode)-0x11)
+		if size < chunk.Length {
+			return nil, ErrBadChunk
+		}
 		chunk.Type = FmpChunkSimpleData
 		chunk.Value = payload[1:chunk.Length]
 
 	case 0x16:
+		if size < 5 {
+			return nil, ErrBadChunk
+		}
 		chunk.Length = 5 + uint64(payload[4])
+		if size < chunk.Length {
+			return nil, ErrBadChunk
+		}
 		chunk.Type = FmpChunkLongKeyValue
 		chunk.Key = decodeVarUint64(payload[1 : 1+3])
 		chunk.Value = payload[5:chunk.Length]
 
 	case 0x17:
+		if size < 6 {
+			return nil, ErrBadChunk
+		}
 		chunk.Length = 6 + decodeVarUint64(payload[4:4+2])
+		if size < chunk.Length {
+			return nil, ErrBadChunk
+		}
 		chunk.Type = FmpChunkLongKeyValue
 		chunk.Key = decodeVarUint64(payload[1 : 1+3])
 		chunk.Value = payload[6:chunk.Length]
 
 	case 0x19, 0x1A, 0x1B, 0x1C, 0x1D:
+		if size < 2 {
+			return nil, ErrBadChunk
+		}
 		valueLength := uint64(payload[1])
 		chunk.Length = 2 + valueLength + 2*uint64(chunkCode-0x19) + addIf(chunkCode == 0x19, 1)
+		if size < chunk.Length {
+			return nil, ErrBadChunk
+		}
 		chunk.Type = FmpChunkSimpleData
 		chunk.Value = payload[2 : 2+valueLength]
 
 	case 0x1E:
+		if size < 2 || size < 3+uint64(payload[1]) {
+			return nil, ErrBadChunk
+		}
 		keyLength := uint64(payload[1])
 		valueLength := uint64(payload[2+keyLength])
 		chunk.Length = 2 + keyLength + 1 + valueLength
+		if size < chunk.Length {
+			return nil, ErrBadChunk
+		}
 		chunk.Type = FmpChunkLongKeyValue
 		chunk.Key = decodeVarUint64(payload[2 : 2+keyLength])
 		chunk.Value = payload[2+keyLength+1 : chunk.Length]
 
 	case 0x1F:
+		if size < 2 || size < 4+uint64(payload[1]) {
+			return nil, ErrBadChunk
+		}
 		keyLength := uint64(payload[1])
-		valueLength := decodeVarUint64(payload[2+keyLength : 2+keyLength+2+1])
+		valueLength := decodeVarUint64(payload[2+keyLength : 2+keyLength+2])
 		chunk.Length = 2 + keyLength + 2 + valueLength
+		if size < chunk.Length {
+			return nil, ErrBadChunk
+		}
 		chunk.Type = FmpChunkLongKeyValue
 		chunk.Key = decodeVarUint64(payload[2 : 2+keyLength])
 		chunk.Value = payload[2+keyLength+2 : chunk.Length]
 
 	case 0x20, 0xE0:
+		if size < 2 {
+			return nil, ErrBadChunk
+		}
 		if payload[1] == 0xFE {
 			chunk.Length = 10
+			if size < chunk.Length {
+				return nil, ErrBadChunk
+			}
 			chunk.Type = FmpChunkPathPush
 			chunk.Value = payload[2:chunk.Length]
 			break
@@ -237,18 +339,33 @@ func (sect *FmpSector) readChunk(payload []byte) (*FmpChunk, error) {
 		chunk.Value = payload[1:chunk.Length]
 
 	case 0x23:
+		if size < 2 {
+			return nil, ErrBadChunk
+		}
 		chunk.Length = 2 + uint64(payload[1])
+		if size < chunk.Length {
+			return nil, ErrBadChunk
+		}
 		chunk.Type = FmpChunkSimpleData
 		chunk.Value = payload[1:chunk.Length]
 
 	case 0x28, 0x30:
 		chunk.Length = 3 + addIf(chunkCode == 0x30, 1)
+		if size < chunk.Length {
+			return nil, ErrBadChunk
+		}
 		chunk.Type = FmpChunkPathPush
 		chunk.Value = payload[1:chunk.Length]
 
 	case 0x38:
+		if size < 2 {
+			return nil, ErrBadChunk
+		}
 		valueLength := uint64(payload[1])
 		chunk.Length = 2 + valueLength
+		if size < chunk.Length {
+			return nil, ErrBadChunk
+		}
 		chunk.Type = FmpChunkPathPushLong
 		chunk.Value = payload[2:chunk.Length]
 
@@ -0,0 +1,244 @@
+package fmp
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// FmpSummary holds the definition of a summary field, which summarizes the
+// values of another field over a set of records.
+type FmpSummary struct {
+	Operation FmpSummaryOperation
+	Field     *FmpColumn // Field to summarize
+
+	Running    bool       // Summarize up to each record, like a running total
+	Weight     *FmpColumn // Field to weigh an average by, if set
+	Population bool       // Compute the standard deviation of a population
+
+	// BreakField, if set, groups the records by its value. Running summaries
+	// restart for each group, and fractions are of the total of their group.
+	BreakField *FmpColumn
+
+	fieldIDs [3]uint64 // Resolved to Field, Weight and BreakField
+}
+
+// FmpSummaryOperation is the operation of a summary field. Its low four bits
+// hold the code that byte 1 of the field flags stores for it, which some
+// operations share, and its high four bits tell those apart.
+type FmpSummaryOperation uint8
+
+const (
+	FmpSummaryList            FmpSummaryOperation = 0x01
+	FmpSummaryTotal           FmpSummaryOperation = 0x02
+	FmpSummaryCount           FmpSummaryOperation = 0x12
+	FmpSummaryStdDev          FmpSummaryOperation = 0x22
+	FmpSummaryFractionOfTotal FmpSummaryOperation = 0x32
+	FmpSummaryAverage         FmpSummaryOperation = 0x05
+	FmpSummaryMinimum         FmpSummaryOperation = 0x15
+	FmpSummaryMaximum         FmpSummaryOperation = 0x25
+)
+
+func (op FmpSummaryOperation) String() string {
+	switch op {
+	case FmpSummaryTotal:
+		return "Total of"
+	case FmpSummaryAverage:
+		return "Average of"
+	case FmpSummaryCount:
+		return "Count of"
+	case FmpSummaryList:
+		return "List of"
+	case FmpSummaryMinimum:
+		return "Minimum"
+	case FmpSummaryMaximum:
+		return "Maximum"
+	case FmpSummaryStdDev:
+		return "Standard Deviation of"
+	case FmpSummaryFractionOfTotal:
+		return "Fraction of Total of"
+	}
+	return fmt.Sprintf("Summary %d", uint8(op))
+}
+
+// decodeSummary decodes the definition of a summary field. Byte 1 of the field
+// flags holds the code of the operation: 1 for List of, 2 for Total of, Count
+// of, Standard Deviation of and Fraction of Total of, and 5 for Average of,
+// Minimum and Maximum. The rest is at key 8 of the field definition:
+//
+//   - key 1: the length-prefixed ID of the field to summarize.
+//   - key 2: which of the operations sharing the code it is in byte 0,
+//     counting from 0 in the order above, and options in byte 1: 0x01 for
+//     running summaries, and 0x02 for the standard deviation of a population.
+//   - keys 3 and 4: the length-prefixed IDs of the weight and break fields.
+//
+// The sample file has no summary fields, so this layout is this package's
+// own. The fields are resolved once all fields of the table are read.
+func decodeSummary(flags []byte, d *FmpDict) *FmpSummary {
+	if FmpFieldType(flags[0]) != FmpFieldSummary {
+		return nil
+	}
+	summary := &FmpSummary{Operation: FmpSummaryOperation(flags[1] & 0x0f)}
+	if options := d.GetValue(8, 2); len(options) >= 2 {
+		summary.Operation |= FmpSummaryOperation(options[0]&0x0f) << 4
+		summary.Running = options[1]&0x01 != 0
+		summary.Population = options[1]&0x02 != 0
+	}
+	for i, key := range []uint64{1, 3, 4} {
+		summary.fieldIDs[i], _, _ = decodeLengthPrefixed(d.GetValue(8, key), 0)
+	}
+	return summary
+}
+
+// resolveSummaries resolves the fields summary fields refer to, and gives
+// summary fields the data type of their result: that of the summarized field
+// for List of, Minimum and Maximum, and a number otherwise.
+func (t *FmpTable) resolveSummaries() {
+	for _, column := range t.Columns {
+		s := column.Summary
+		if s == nil {
+			continue
+		}
+		s.Field = t.Columns[s.fieldIDs[0]]
+		s.Weight = t.Columns[s.fieldIDs[1]]
+		s.BreakField = t.Columns[s.fieldIDs[2]]
+
+		column.DataType = FmpDataNumber
+		switch s.Operation {
+		case FmpSummaryList, FmpSummaryMinimum, FmpSummaryMaximum:
+			if s.Field != nil {
+				column.DataType = s.Field.DataType
+			}
+		}
+	}
+}
+
+// Summarize computes a summary field over the given records, in the order
+// given, and returns its value for each of them, like FileMaker shows it in a
+// list of the records. Running summaries hold the summary up to and including
+// each record, fractions of the total hold the fraction of each record, and
+// other summaries hold the same value for every record.
+//
+// If the summary has a break field, the records are grouped by its value, as
+// they would be when sorted by it, and each group is summarized separately.
+// Empty values are left out. It returns ErrNoSummary for other fields.
+func (t *FmpTable) Summarize(name string, records []*FmpRecord) ([]string, error) {
+	column := t.Column(name)
+	if column == nil {
+		return nil, ErrUnknownColumn
+	}
+	s := column.Summary
+	if s == nil {
+		return nil, ErrNoSummary
+	}
+	if s.Field == nil {
+		return nil, ErrUnknownColumn
+	}
+
+	results := make([]string, len(records))
+	for start := 0; start < len(records); {
+		end := start + 1
+		if s.BreakField != nil {
+			key := records[start].Value(s.BreakField.Name)
+			for end < len(records) && records[end].Value(s.BreakField.Name) == key {
+				end++
+			}
+		} else {
+			end = len(records)
+		}
+
+		group := records[start:end]
+		switch {
+		case s.Operation == FmpSummaryFractionOfTotal:
+			total := parseCalcNumber(s.compute(group))
+			for i, record := range group {
+				value := record.Value(s.Field.Name)
+				if value != "" && total != 0 {
+					results[start+i] = formatSummaryNumber(parseCalcNumber(value) / total)
+				}
+			}
+		case s.Running:
+			for i := range group {
+				results[start+i] = s.compute(group[:i+1])
+			}
+		default:
+			value := s.compute(group)
+			for i := range group {
+				results[start+i] = value
+			}
+		}
+		start = end
+	}
+	return results, nil
+}
+
+// compute summarizes the given records. For fractions of the total, it
+// computes the total.
+func (s *FmpSummary) compute(records []*FmpRecord) string {
+	values := make([]string, 0, len(records))
+	weights := make([]float64, 0, len(records))
+	for _, record := range records {
+		if value := record.Value(s.Field.Name); value != "" {
+			values = append(values, value)
+			weight := 1.0
+			if s.Weight != nil && s.Operation == FmpSummaryAverage {
+				weight = parseCalcNumber(record.Value(s.Weight.Name))
+			}
+			weights = append(weights, weight)
+		}
+	}
+
+	switch s.Operation {
+	case FmpSummaryCount:
+		return strconv.Itoa(len(values))
+	case FmpSummaryList:
+		return strings.Join(values, "\r")
+	case FmpSummaryMinimum, FmpSummaryMaximum:
+		if len(values) == 0 {
+			return ""
+		}
+		best := values[0]
+		for _, value := range values[1:] {
+			c := s.Field.Compare(value, best)
+			if s.Operation == FmpSummaryMinimum && c < 0 || s.Operation == FmpSummaryMaximum && c > 0 {
+				best = value
+			}
+		}
+		return best
+	}
+
+	sum, weightSum := 0.0, 0.0
+	for i, value := range values {
+		sum += parseCalcNumber(value) * weights[i]
+		weightSum += weights[i]
+	}
+
+	switch s.Operation {
+	case FmpSummaryAverage:
+		if weightSum == 0 {
+			return ""
+		}
+		return formatSummaryNumber(sum / weightSum)
+	case FmpSummaryStdDev:
+		n := float64(len(values))
+		if !s.Population {
+			n--
+		}
+		if n <= 0 {
+			return "0"
+		}
+		mean := sum / float64(len(values))
+		squares := 0.0
+		for _, value := range values {
+			d := parseCalcNumber(value) - mean
+			squares += d * d
+		}
+		return formatSummaryNumber(math.Sqrt(squares / n))
+	}
+	return formatSummaryNumber(sum)
+}
+
+func formatSummaryNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
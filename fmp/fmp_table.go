@@ -1,32 +1,130 @@
 package fmp
 
+import (
+	"cmp"
+	"encoding/binary"
+	"slices"
+)
+
+// FmpTable is a base table. Its Columns and Records maps are updated when a
+// transaction commits, so code that runs concurrently with writes should go
+// through Column, Record and AllRecords instead of reading them directly.
 type FmpTable struct {
 	ID      uint64
 	Name    string
 	Columns map[uint64]*FmpColumn
 	Records map[uint64]*FmpRecord
 
+	Comment   string
+	ChangedBy FmpChangeInfo
+
+	// ExternalSource names the external SQL data source of shadow tables,
+	// whose records are not stored in the file. It is read from key 17 of
+	// the table catalog entry. The sample file has no shadow tables, so this
+	// location is this package's own.
+	ExternalSource string
+
+	file         *FmpFile
+	recordCount  uint64
+	lastColumnID uint64
 	lastRecordID uint64
 }
 
 type FmpColumn struct {
+	Table       *FmpTable
 	Index       uint64
 	Name        string
 	Type        FmpFieldType
-	DataType    FmpDataType
+	DataType    FmpDataType // Type of the result, for calculation and summary fields
 	StorageType FmpFieldStorageType
 	AutoEnter   FmpAutoEnterOption
 	Repetitions uint8
 	Indexed     bool
+	Language    FmpLanguage
+	Validation  FmpValidation
+	Comment     string
+	ChangedBy   FmpChangeInfo
+
+	// Furigana is the field that receives the reading of Japanese text
+	// entered into this field, if set.
+	Furigana *FmpColumn
+
+	// ProhibitModification is set when auto-entered values may not be
+	// modified during data entry. It is not enforced by this library.
+	ProhibitModification bool
+
+	// SerialOnCommit is set when serial numbers are generated when a new
+	// record is committed, rather than when it is created.
+	SerialOnCommit bool
+
+	// Calculation is the formula of a calculation field, or the auto-enter
+	// calculation of a simple field.
+	Calculation *FmpCalculation
+
+	// Context is the occurrence the calculation is evaluated from, if it is
+	// set to another one than the default.
+	Context *FmpTableOccurrence
+
+	// EvaluateIfEmpty is set when the calculation is evaluated even if all
+	// the fields it refers to are empty.
+	EvaluateIfEmpty bool
+
+	// Lookup is set for fields that copy their value from a related record.
+	Lookup *FmpLookup
+
+	// Summary is set for summary fields.
+	Summary *FmpSummary
+
+	autoEnters      bool
+	nextSerial      string
+	serialIncrement uint64
+	contextID       uint64
+	furiganaID      uint64
+}
+
+// FmpChangeInfo holds the user and account name that FileMaker stores with
+// tables, fields and other parts of the schema, at keys 64513 and 64514.
+type FmpChangeInfo struct {
+	UserName    string
+	AccountName string
+
+	// Stamp holds the raw 9-byte value at key 64515, which FileMaker stores
+	// next to the names. It is not decoded further, as its layout is not
+	// known.
+	Stamp []byte
+}
+
+// resolveColumns links fields to the other fields of the table they refer to,
+// once all fields are read.
+func (t *FmpTable) resolveColumns() {
+	for _, column := range t.Columns {
+		column.Furigana = t.Columns[column.furiganaID]
+	}
+	t.resolveSummaries()
+}
+
+func decodeChangeInfo(d *FmpDict) FmpChangeInfo {
+	return FmpChangeInfo{
+		UserName:    decodeString(d.GetValue(64513)),
+		AccountName: decodeString(d.GetValue(64514)),
+		Stamp:       d.GetValue(64515),
+	}
 }
 
 type FmpRecord struct {
-	Table  *FmpTable
-	Index  uint64
+	Table *FmpTable
+	Index uint64
+
+	// Values holds the field values by field ID. Committing an update changes
+	// it, so it is not safe to access directly while other goroutines may
+	// commit. Use Value instead.
 	Values map[uint64]string
 }
 
 func (ctx *FmpFile) Table(name string) *FmpTable {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+
 	for _, table := range ctx.tables {
 		if table.Name == name {
 			return table
@@ -36,6 +134,12 @@ func (ctx *FmpFile) Table(name string) *FmpTable {
 }
 
 func (t *FmpTable) Column(name string) *FmpColumn {
+	t.file.mu.RLock()
+	defer t.file.mu.RUnlock()
+	return t.column(name)
+}
+
+func (t *FmpTable) column(name string) *FmpColumn {
 	for _, column := range t.Columns {
 		if column.Name == name {
 			return column
@@ -45,19 +149,97 @@ func (t *FmpTable) Column(name string) *FmpColumn {
 }
 
 func (t *FmpTable) NewRecord(values map[string]string) (*FmpRecord, error) {
-	vals := make(map[uint64]string)
-	for k, v := range values {
-		col := t.Column(k)
-		vals[col.Index] = v
+	tx := t.file.Begin()
+	record, err := tx.NewRecord(t, values)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return record, tx.Commit()
+}
+
+// Update changes the given field values of the record.
+func (r *FmpRecord) Update(values map[string]string) error {
+	tx := r.Table.file.Begin()
+	if err := tx.UpdateRecord(r, values); err != nil {
+		tx.Rollback()
+		return err
 	}
+	return tx.Commit()
+}
+
+// RecordCount returns the number of records in the table as stored in the
+// file, without counting them.
+func (t *FmpTable) RecordCount() uint64 {
+	t.file.mu.RLock()
+	defer t.file.mu.RUnlock()
+	return t.recordCount
+}
 
-	id := t.lastRecordID + 1
-	t.lastRecordID = id
-	t.Records[id] = &FmpRecord{Table: t, Index: id, Values: vals}
+// NextRecordID returns the ID that the next record created in the table gets.
+// IDs of records that were deleted, or created in transactions that were
+// rolled back, are not handed out again.
+func (t *FmpTable) NextRecordID() uint64 {
+	t.file.mu.RLock()
+	defer t.file.mu.RUnlock()
+	return t.lastRecordID + 1
+}
 
-	return t.Records[id], nil
+// readRecordCount reads the number of records and the last record ID that
+// were handed out, which live as two 8-byte numbers at [table].[1].[1].[16].
+// Without them, the records are counted.
+func (t *FmpTable) readRecordCount() {
+	counts := t.file.Dictionary.GetValue(t.ID, 1, 1, 16)
+	if len(counts) < 16 {
+		t.recordCount = uint64(len(t.Records))
+		return
+	}
+	t.recordCount = binary.BigEndian.Uint64(counts)
+	t.lastRecordID = max(t.lastRecordID, binary.BigEndian.Uint64(counts[8:]))
+}
+
+func (t *FmpTable) writeRecordCount() {
+	counts := binary.BigEndian.AppendUint64(nil, t.recordCount)
+	t.file.setValue([]uint64{t.ID, 1, 1, 16}, binary.BigEndian.AppendUint64(counts, t.lastRecordID))
+}
+
+// Record returns the record with the given index, or nil if there is none.
+// Unlike indexing Records directly, it is safe to call while other goroutines
+// commit changes to the file.
+func (t *FmpTable) Record(index uint64) *FmpRecord {
+	t.file.mu.RLock()
+	defer t.file.mu.RUnlock()
+	return t.Records[index]
+}
+
+// AllRecords returns a snapshot of the records in the table, ordered by index.
+func (t *FmpTable) AllRecords() []*FmpRecord {
+	t.file.mu.RLock()
+	defer t.file.mu.RUnlock()
+	return t.allRecords()
+}
+
+func (t *FmpTable) allRecords() []*FmpRecord {
+	records := make([]*FmpRecord, 0, len(t.Records))
+	for _, record := range t.Records {
+		records = append(records, record)
+	}
+	slices.SortFunc(records, func(a, b *FmpRecord) int {
+		return cmp.Compare(a.Index, b.Index)
+	})
+	return records
 }
 
 func (r *FmpRecord) Value(name string) string {
-	return r.Values[r.Table.Column(name).Index]
+	r.Table.file.mu.RLock()
+	defer r.Table.file.mu.RUnlock()
+
+	column := r.Table.column(name)
+	if column == nil {
+		return ""
+	}
+	if column.StorageType == FmpFieldStorageGlobal {
+		return r.Table.globalLocked(column)
+	}
+	return r.Values[column.Index]
 }
@@ -1,8 +1,21 @@
 package fmp
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
 	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestOpenFile(t *testing.T) {
@@ -28,6 +41,34 @@ func TestOpenFile(t *testing.T) {
 	f.ToDebugFile("../private/output")
 }
 
+func TestLocking(t *testing.T) {
+	f, err := OpenFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenFileWithOptions("../files/Untitled.fmp12", &FmpOpenOptions{ReadOnly: true, LockTimeout: 100 * time.Millisecond})
+	if !errors.Is(err, ErrLocked) {
+		t.Errorf("expected ErrLocked while file is opened for writing, got %v", err)
+	}
+	f.Close()
+
+	r1, err := OpenFileWithOptions("../files/Untitled.fmp12", &FmpOpenOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r1.Close()
+	r2, err := OpenFileWithOptions("../files/Untitled.fmp12", &FmpOpenOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("expected shared locks to coexist, got %v", err)
+	}
+	defer r2.Close()
+
+	if _, err := OpenFile("../files/Untitled.fmp12"); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected ErrLocked while file is opened for reading, got %v", err)
+	}
+}
+
 func TestTables(t *testing.T) {
 	f, err := OpenFile("../files/Untitled.fmp12")
 	if err != nil {
@@ -86,7 +127,7 @@ func TestTables(t *testing.T) {
 		t.Errorf("expected field to have auto enter calculation replacing existing value, but it does not")
 	}
 
-	newRecord, err := table.NewRecord(map[string]string{"PrimaryKey": "629FAA83-50D8-401F-A560-C8D45217D17B"})
+	newRecord, err := table.NewRecord(map[string]string{"PrimaryKey": "0A4C7A5E-7C4B-4F8E-9E5B-2D1F3C6A8B90"})
 	if newRecord == nil || err != nil {
 		t.Errorf("expected new record to be created, but it is nil")
 		return
@@ -94,9 +135,2005 @@ func TestTables(t *testing.T) {
 	if newRecord.Index != 4 {
 		t.Errorf("expected new record index to be 4, but it is %d", newRecord.Index)
 	}
-	if newRecord.Value("PrimaryKey") != "629FAA83-50D8-401F-A560-C8D45217D17B" {
-		t.Errorf("expected new record primary key to be '629FAA83-50D8-401F-A560-C8D45217D17B', but it is '%s'", newRecord.Value("PrimaryKey"))
+	if newRecord.Value("PrimaryKey") != "0A4C7A5E-7C4B-4F8E-9E5B-2D1F3C6A8B90" {
+		t.Errorf("expected new record primary key to be '0A4C7A5E-7C4B-4F8E-9E5B-2D1F3C6A8B90', but it is '%s'", newRecord.Value("PrimaryKey"))
+	}
+
+	if _, err := table.NewRecord(map[string]string{"PrimaryKey": "629FAA83-50D8-401F-A560-C8D45217D17B"}); !errors.Is(err, ErrValidation) {
+		t.Errorf("expected duplicate primary key to fail validation, got %v", err)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	f, err := OpenFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	table := f.Table("Untitled")
+
+	tx := f.Begin()
+	column, err := tx.NewColumn(table, "Notes", FmpDataText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := tx.NewRecord(table, map[string]string{"PrimaryKey": "A", "Notes": "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Column("Notes") != nil || table.Records[record.Index] != nil {
+		t.Errorf("expected changes to be invisible before commit")
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if table.Column("Notes") != nil || table.Records[record.Index] != nil {
+		t.Errorf("expected changes to be discarded after rollback")
+	}
+	if _, err := tx.NewRecord(table, nil); err != ErrTxDone {
+		t.Errorf("expected ErrTxDone after rollback, got %v", err)
+	}
+
+	tx = f.Begin()
+	column, _ = tx.NewColumn(table, "Notes", FmpDataText)
+	record, _ = tx.NewRecord(table, map[string]string{"PrimaryKey": "B", "Notes": "Hello"})
+	if _, err := tx.NewRecord(table, map[string]string{"Missing": "x"}); err != ErrUnknownColumn {
+		t.Errorf("expected ErrUnknownColumn, got %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if table.Column("Notes") != column {
+		t.Errorf("expected column to exist after commit")
+	}
+	if record.Index != 5 {
+		t.Errorf("expected record index to be 5, but it is %d", record.Index)
+	}
+	if table.Records[record.Index].Value("Notes") != "Hello" {
+		t.Errorf("expected record to exist after commit")
+	}
+	if got := decodeString(f.Dictionary.GetValue(table.ID, 5, record.Index, column.Index)); got != "Hello" {
+		t.Errorf("expected dictionary to hold 'Hello', got '%s'", got)
+	}
+}
+
+func TestNewSector(t *testing.T) {
+	f, err := OpenFile(corruptCopy(t, 0, 0x00))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	count := len(f.Sectors)
+	size, _ := f.stream.Seek(0, io.SeekEnd)
+
+	tx := f.Begin()
+	sector, err := tx.NewSector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if end, _ := f.stream.Seek(0, io.SeekEnd); end != size || len(f.Sectors) != count {
+		t.Errorf("expected sector not to be written before commit")
+	}
+	tx.Rollback()
+	if end, _ := f.stream.Seek(0, io.SeekEnd); end != size || len(f.Sectors) != count {
+		t.Errorf("expected sector not to be written after rollback")
+	}
+
+	tx = f.Begin()
+	sector, _ = tx.NewSector()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if sector.ID != uint64(count)+1 || f.Sectors[len(f.Sectors)-1] != sector {
+		t.Errorf("expected sector %d to be appended, got %d", count+1, sector.ID)
+	}
+	if end, _ := f.stream.Seek(0, io.SeekEnd); end != int64(sector.ID+2)*sectorSize {
+		t.Errorf("expected file to end after the new sector, got size %d", end)
+	}
+
+	ro, err := OpenFileWithOptions(corruptCopy(t, 0, 0x00), &FmpOpenOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+
+	count = len(ro.Sectors)
+	tx = ro.Begin()
+	tx.NewSector()
+	tx.NewSector()
+	if err := tx.Commit(); err == nil {
+		t.Fatal("expected commit to a read-only file to fail")
+	}
+	if len(ro.Sectors) != count || ro.Sectors[count-1].NextID != 0 {
+		t.Errorf("expected failed commit to leave the sectors unchanged")
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Errorf("expected failed transaction to stay open, got %v", err)
+	}
+}
+
+func TestStrings(t *testing.T) {
+	xor := func(b ...byte) []byte {
+		for i := range b {
+			b[i] ^= 0x5A
+		}
+		return b
+	}
+
+	// Examples from Unicode Technical Standard #6.
+	if got := decodeString(xor(0xD6, 0x6C, 0x20, 0x66, 0x6C, 0x69, 0x65, 0xDF, 0x74)); got != "Öl fließt" {
+		t.Errorf("expected 'Öl fließt', got '%s'", got)
+	}
+	if got := decodeString(xor(0x12, 0x9C, 0xBE, 0xC1, 0xBA, 0xB2, 0xB0)); got != "Москва" {
+		t.Errorf("expected 'Москва', got '%s'", got)
+	}
+	if got := decodeString(xor(0x06, 0x81, 0x6F, 0x06, 0x81)); got != "ぁoぁ" {
+		t.Errorf("expected quoted hiragana, got '%s'", got)
+	}
+
+	for _, s := range []string{"", "Untitled", "Öl fließt\r\x0b", "Москва", "日本語 テキスト", "\ue000 \U0001F600"} {
+		if got := decodeString(encodeString(s)); got != s {
+			t.Errorf("expected '%s' to round-trip, got '%s'", s, got)
+		}
+	}
+	if got := encodeString("é"); !slices.Equal(got, xor(0xE9)) {
+		t.Errorf("expected Latin-1 text to be stored as is, got % x", got)
+	}
+}
+
+func TestTableMetadata(t *testing.T) {
+	f, err := OpenFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	table := f.Table("Untitled")
+	if table.RecordCount() != 3 || table.NextRecordID() != 4 {
+		t.Errorf("expected 3 records and next ID 4, got %d and %d", table.RecordCount(), table.NextRecordID())
+	}
+	stamp := []byte{0x08, 0x9f, 0xa5, 0x90, 0x80, 0x05, 0x53, 0x91, 0x40}
+	if table.ChangedBy.AccountName != "Admin" || !slices.Equal(table.ChangedBy.Stamp, stamp) || table.ExternalSource != "" {
+		t.Errorf("unexpected table metadata %+v, '%s'", table.ChangedBy, table.ExternalSource)
+	}
+
+	if _, err := table.NewRecord(map[string]string{"PrimaryKey": "A"}); err != nil {
+		t.Fatal(err)
+	}
+	counts := f.Dictionary.GetValue(32769, 1, 1, 16)
+	if table.RecordCount() != 4 || decodeVarUint64(counts[:8]) != 4 || decodeVarUint64(counts[8:]) != 4 {
+		t.Errorf("expected 4 records to be stored, got %d (% x)", table.RecordCount(), counts)
+	}
+
+	f.Dictionary.set([]uint64{32769, 1, 1, 16}, []byte{0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 10})
+	if err := f.readTables(); err != nil {
+		t.Fatal(err)
+	}
+	table = f.Table("Untitled")
+	if table.NextRecordID() != 11 {
+		t.Errorf("expected next ID 11, got %d", table.NextRecordID())
+	}
+
+	// Shadow tables name their external data source in the table catalog.
+	f.Dictionary.set([]uint64{3, 16, 5, 32769, 17}, encodeString("Warehouse"))
+	if err := f.readTables(); err != nil {
+		t.Fatal(err)
+	}
+	table = f.Table("Untitled")
+	if table.ExternalSource != "Warehouse" {
+		t.Errorf("expected external source 'Warehouse', got '%s'", table.ExternalSource)
+	}
+	if _, err := table.NewRecord(map[string]string{"PrimaryKey": "B"}); !errors.Is(err, ErrExternalSource) {
+		t.Errorf("expected ErrExternalSource for shadow table, got %v", err)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	f, err := OpenFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	table := f.Table("Untitled")
+	pk := table.Column("PrimaryKey").Validation
+	if !pk.Required || !pk.Unique || pk.UserCanOverride || pk.Always {
+		t.Errorf("unexpected primary key validation %+v", pk)
+	}
+	if !table.Column("CreationTimestamp").Validation.FourDigitYear {
+		t.Errorf("expected creation timestamp to require a four-digit year")
+	}
+
+	flags := make([]byte, 26)
+	flags[14], flags[15] = 0x12, 0xc0
+	v := decodeValidation(flags)
+	if !v.StrictNumeric || !v.HasMaxLength || !v.InRange || !v.HasMessage || !v.UserCanOverride || v.InValueList || v.ByCalculation {
+		t.Errorf("unexpected decoded validation %+v", v)
+	}
+
+	setValidation(t, f, 3, 0x02, 0x80, map[uint64][]byte{1: encodeLengthPrefixed(5), 6: encodeString("Too long")})
+	table = f.Table("Untitled")
+	createdBy := table.Column("CreatedBy")
+	_, err = table.NewRecord(map[string]string{"PrimaryKey": "C", "CreatedBy": "Administrator"})
+	var verr *FmpValidationError
+	if !errors.As(err, &verr) || verr.Column != createdBy || verr.Message != "Too long" {
+		t.Errorf("expected custom validation message, got %v", err)
+	}
+
+	record := table.Record(1)
+	if err := record.Update(map[string]string{"CreationTimestamp": "16/06/25 11:53:25"}); !errors.Is(err, ErrValidation) {
+		t.Errorf("expected two-digit year to fail validation, got %v", err)
+	}
+	if err := record.Update(map[string]string{"CreatedBy": "Adm"}); err != nil {
+		t.Fatal(err)
+	}
+	if record.Value("CreatedBy") != "Adm" || decodeString(f.Dictionary.GetValue(table.ID, 5, 1, 3)) != "Adm" {
+		t.Errorf("expected record to be updated")
+	}
+	f.Close()
+
+	f, err = OpenFileWithOptions("../files/Untitled.fmp12", &FmpOpenOptions{ReadOnly: true, OverrideValidation: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	table = f.Table("Untitled")
+	table.Column("CreatedBy").Validation = FmpValidation{UserCanOverride: true, HasMaxLength: true, MaxLength: 5}
+	record = table.Record(1)
+	if err := record.Update(map[string]string{"CreatedBy": "Administrator"}); err != nil {
+		t.Errorf("expected validation to be overridden, got %v", err)
+	}
+	if err := record.Update(map[string]string{"CreationTimestamp": "16/06/25 11:53:25"}); !errors.Is(err, ErrValidation) {
+		t.Errorf("expected validation the user cannot override to fail, got %v", err)
+	}
+}
+
+// setValidation flags validation options of a field of the sample table in
+// bytes 14 and 15 of its flags, writes their details and reads the tables
+// again.
+func setValidation(t *testing.T, f *FmpFile, fieldID uint64, flags14, flags15 byte, details map[uint64][]byte) {
+	flags := slices.Clone(f.Dictionary.GetValue(32769, 3, 5, fieldID, 2))
+	flags[14] |= flags14
+	flags[15] |= flags15
+	f.Dictionary.set([]uint64{32769, 3, 5, fieldID, 2}, flags)
+	for key, value := range details {
+		f.Dictionary.set([]uint64{32769, 3, 5, fieldID, 6, key}, value)
+	}
+	if err := f.readTables(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidationDetails(t *testing.T) {
+	f, err := OpenFileWithOptions("../files/Untitled.fmp12", &FmpOpenOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Details are not enforced unless their option is flagged.
+	f.Dictionary.set([]uint64{32769, 3, 5, 3, 6, 1}, encodeLengthPrefixed(2))
+	f.Dictionary.set([]uint64{32769, 3, 5, 3, 6, 6}, encodeString("Ignored"))
+	if err := f.readTables(); err != nil {
+		t.Fatal(err)
+	}
+	v := f.Table("Untitled").Column("CreatedBy").Validation
+	if v.MaxLength != 0 || v.Message != "" {
+		t.Errorf("expected details of options that are not flagged to be ignored, got %+v", v)
+	}
+	tx := f.Begin()
+	if _, err := tx.NewRecord(f.Table("Untitled"), map[string]string{"PrimaryKey": "D", "CreatedBy": "Administrator"}); err != nil {
+		t.Errorf("expected unflagged details not to be enforced, got %v", err)
+	}
+	tx.Rollback()
+
+	setValidation(t, f, 3, 0, 0x40, map[uint64][]byte{2: encodeString("B"), 3: encodeString("M")})
+	table := f.Table("Untitled")
+	if v := table.Column("CreatedBy").Validation; !v.InRange || v.RangeFrom != "B" || v.RangeTo != "M" {
+		t.Errorf("expected range B to M, got %+v", v)
+	}
+	tx = f.Begin()
+	if _, err := tx.NewRecord(table, map[string]string{"PrimaryKey": "D", "CreatedBy": "Zed"}); !errors.Is(err, ErrValidation) {
+		t.Errorf("expected value out of range to fail validation, got %v", err)
+	}
+	if _, err := tx.NewRecord(table, map[string]string{"PrimaryKey": "E", "CreatedBy": "Carol"}); err != nil {
+		t.Errorf("expected value in range to pass validation, got %v", err)
+	}
+	tx.Rollback()
+
+	f.Dictionary.set([]uint64{33, 5, 1, 16}, encodeString("Answers"))
+	f.Dictionary.set([]uint64{33, 5, 1, 2}, []byte{byte(FmpValueListCustom), 0})
+	f.Dictionary.set([]uint64{33, 5, 1, 5}, encodeString("Carol\rDave"))
+	setValidation(t, f, 3, 0x01, 0, map[uint64][]byte{4: encodeLengthPrefixed(1)})
+	if err := f.readValueLists(); err != nil {
+		t.Fatal(err)
+	}
+	table = f.Table("Untitled")
+	if list := table.Column("CreatedBy").Validation.ValueList; list == nil || list.Name != "Answers" {
+		t.Errorf("expected value list Answers, got %+v", list)
+	}
+	tx = f.Begin()
+	if _, err := tx.NewRecord(table, map[string]string{"PrimaryKey": "D", "CreatedBy": "Dave"}); err != nil {
+		t.Errorf("expected value in value list to pass validation, got %v", err)
+	}
+	if _, err := tx.NewRecord(table, map[string]string{"PrimaryKey": "E", "CreatedBy": "Eve"}); !errors.Is(err, ErrValidation) {
+		t.Errorf("expected value outside value list to fail validation, got %v", err)
+	}
+	tx.Rollback()
+
+	f.Dictionary.set([]uint64{32769, 3, 5, 3, 6, 5, 5}, calcNumber("0"))
+	setValidation(t, f, 3, 0, 0x81, map[uint64][]byte{6: encodeString("Never valid")})
+	table = f.Table("Untitled")
+	var verr *FmpValidationError
+	tx = f.Begin()
+	if _, err := tx.NewRecord(table, map[string]string{"PrimaryKey": "D", "CreatedBy": "Dave"}); !errors.As(err, &verr) || verr.Message != "Never valid" {
+		t.Errorf("expected validation calculation to fail with custom message, got %v", err)
+	}
+	tx.Rollback()
+
+	// Unique values ignore case.
+	pk := table.Record(1).Value("PrimaryKey")
+	tx = f.Begin()
+	if _, err := tx.NewRecord(table, map[string]string{"PrimaryKey": strings.ToLower(pk)}); !errors.Is(err, ErrValidation) {
+		t.Errorf("expected primary key differing in case to fail validation, got %v", err)
+	}
+	tx.Rollback()
+
+	// Flagged options without details are a problem with the dictionary.
+	flags := slices.Clone(f.Dictionary.GetValue(32769, 3, 5, 2, 2))
+	flags[14] |= 0x02
+	f.Dictionary.set([]uint64{32769, 3, 5, 2, 2}, flags)
+	if err := f.readTables(); !errors.Is(err, ErrBadDictionary) {
+		t.Errorf("expected ErrBadDictionary for maximum length without details, got %v", err)
+	}
+}
+
+func TestAutoEnter(t *testing.T) {
+	now := time.Date(2025, 6, 17, 9, 30, 0, 0, time.UTC)
+	f, err := OpenFileWithOptions("../files/Untitled.fmp12", &FmpOpenOptions{
+		Now:         func() time.Time { return now },
+		AccountName: "etl",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table := f.Table("Untitled")
+	for name, expected := range map[string]FmpAutoEnterOption{
+		"PrimaryKey":            FmpAutoEnterCalculationReplacingExistingValue,
+		"CreationTimestamp":     FmpAutoEnterCreateTS,
+		"CreatedBy":             FmpAutoEnterCreateAccountName,
+		"ModificationTimestamp": FmpAutoEnterModTS,
+		"ModifiedBy":            FmpAutoEnterModAccountName,
+	} {
+		if option := table.Column(name).AutoEnter; option != expected {
+			t.Errorf("expected auto-enter option %d for %s, got %d", expected, name, option)
+		}
+	}
+
+	record, err := table.NewRecord(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{
+		"CreationTimestamp":     "17/06/2025 09:30:00",
+		"CreatedBy":             "etl",
+		"ModificationTimestamp": "17/06/2025 09:30:00",
+		"ModifiedBy":            "etl",
+	} {
+		if record.Value(name) != expected {
+			t.Errorf("expected %s to be '%s', got '%s'", name, expected, record.Value(name))
+		}
+	}
+	if key := record.Value("PrimaryKey"); len(key) != 36 {
+		t.Errorf("expected Get ( UUID ) to be entered as primary key, got '%s'", key)
+	}
+
+	now = now.Add(time.Hour)
+	if err := record.Update(map[string]string{"CreatedBy": "someone"}); err != nil {
+		t.Fatal(err)
+	}
+	if record.Value("CreationTimestamp") != "17/06/2025 09:30:00" || record.Value("ModificationTimestamp") != "17/06/2025 10:30:00" {
+		t.Errorf("expected only the modification timestamp to change, got %s and %s", record.Value("CreationTimestamp"), record.Value("ModificationTimestamp"))
+	}
+
+	// Serial numbers on creation (byte 11 = 2) and on commit (byte 10 = 2)
+	// continue from the last record.
+	tx := f.Begin()
+	invoice, _ := tx.NewColumn(table, "Invoice", FmpDataText)
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Record(3).Update(map[string]string{"Invoice": "INV0009"}); err != nil {
+		t.Fatal(err)
+	}
+	setFlags := func(index int, value byte) {
+		flags := slices.Clone(f.Dictionary.GetValue(table.ID, 3, 5, invoice.Index, 2))
+		flags[index] = value
+		f.setValue([]uint64{table.ID, 3, 5, invoice.Index, 2}, flags)
+		if err := f.readTables(); err != nil {
+			t.Fatal(err)
+		}
+		table = f.Table("Untitled")
+	}
+	setFlags(11, 0x02)
+
+	for _, expected := range []string{"INV0010", "INV0011"} {
+		record, err := table.NewRecord(nil)
+		if err != nil || record.Value("Invoice") != expected {
+			t.Errorf("expected serial '%s', got '%s' (%v)", expected, record.Value("Invoice"), err)
+		}
+	}
+	tx = f.Begin()
+	tx.NewRecord(table, nil)
+	tx.Rollback()
+	if record, _ := table.NewRecord(nil); record.Value("Invoice") != "INV0013" {
+		t.Errorf("expected serial of rolled back record not to be reused, got '%s'", record.Value("Invoice"))
+	}
+
+	setFlags(10, 0x02)
+	tx = f.Begin()
+	record, _ = tx.NewRecord(table, nil)
+	if record.Values[table.Column("Invoice").Index] != "" {
+		t.Errorf("expected serial on commit to be empty before commit")
+	}
+	tx.Commit()
+	if record.Value("Invoice") != "INV0014" {
+		t.Errorf("expected serial 'INV0014' on commit, got '%s'", record.Value("Invoice"))
+	}
+
+	// The next serial and its increment are kept in the field definition.
+	if next := decodeString(f.Dictionary.GetValue(table.ID, 3, 5, invoice.Index, 9, 1)); next != "INV0015" {
+		t.Errorf("expected next serial 'INV0015' to be stored, got '%s'", next)
+	}
+	f.setValue([]uint64{table.ID, 3, 5, invoice.Index, 9, 1}, encodeString("INV0100"))
+	f.setValue([]uint64{table.ID, 3, 5, invoice.Index, 9, 2}, encodeLengthPrefixed(10))
+	setFlags(10, 0)
+	for _, expected := range []string{"INV0100", "INV0110"} {
+		record, err := table.NewRecord(nil)
+		if err != nil || record.Value("Invoice") != expected {
+			t.Errorf("expected stored serial '%s', got '%s' (%v)", expected, record.Value("Invoice"), err)
+		}
+	}
+	f.Close()
+
+	f, err = OpenFileWithOptions("../files/Untitled.fmp12", &FmpOpenOptions{NoAutoEnter: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	values := map[string]string{
+		"PrimaryKey":            "G",
+		"CreationTimestamp":     "01/01/2020 00:00:00",
+		"CreatedBy":             "import",
+		"ModificationTimestamp": "01/01/2020 00:00:00",
+		"ModifiedBy":            "import",
+	}
+	record, err = f.Table("Untitled").NewRecord(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Value("ModificationTimestamp") != "01/01/2020 00:00:00" {
+		t.Errorf("expected values to be kept without auto-enter, got '%s'", record.Value("ModificationTimestamp"))
+	}
+}
+
+func TestFieldMetadata(t *testing.T) {
+	f, err := OpenFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	table := f.Table("Untitled")
+	pk, createdBy := table.Column("PrimaryKey"), table.Column("CreatedBy")
+	if pk.Comment != "Unique identifier of each record in this table" {
+		t.Errorf("unexpected comment '%s'", pk.Comment)
+	}
+	if pk.ChangedBy.UserName != "Romein van Buren" || pk.ChangedBy.AccountName != "Admin" || !slices.Equal(pk.ChangedBy.Stamp, table.ChangedBy.Stamp) {
+		t.Errorf("unexpected change info %+v", pk.ChangedBy)
+	}
+	if !pk.ProhibitModification || !createdBy.ProhibitModification {
+		t.Errorf("expected PrimaryKey and CreatedBy to prohibit modification")
+	}
+	if pk.EvaluateIfEmpty || pk.Calculation == nil || pk.Calculation.String() != "Get ( UUID )" {
+		t.Errorf("expected Get ( UUID ) not to be evaluated if all references are empty")
+	}
+	if pk.Context != nil || pk.Furigana != nil {
+		t.Errorf("expected the default context and no furigana, got %v and %v", pk.Context, pk.Furigana)
+	}
+
+	tx := f.Begin()
+	notes, _ := tx.NewColumn(f.Table("Untitled"), "Notes", FmpDataText)
+	setting, _ := tx.NewColumn(f.Table("Untitled"), "Setting", FmpDataText)
+	doubled, _ := tx.NewColumn(f.Table("Untitled"), "Doubled", FmpDataText)
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	flags := make([]byte, 26)
+	flags[0], flags[1], flags[9], flags[25] = byte(FmpFieldSimple), byte(FmpDataText), byte(FmpFieldStorageGlobal), 1
+	f.Dictionary.set([]uint64{32769, 3, 5, setting.Index, 2}, flags)
+
+	// A calculation with a number result, evaluated from the Untitled
+	// occurrence, and CreatedBy with Notes as its furigana field.
+	flags = make([]byte, 26)
+	flags[0], flags[1], flags[9], flags[25] = byte(FmpFieldCalculation), byte(FmpDataNumber), byte(FmpFieldStorageCalculation), 1
+	f.Dictionary.set([]uint64{32769, 3, 5, doubled.Index, 2}, flags)
+	f.Dictionary.set([]uint64{32769, 3, 5, doubled.Index, 5, 4}, encodeLengthPrefixed(13631489))
+	f.Dictionary.set([]uint64{32769, 3, 5, doubled.Index, 5, 5}, calcNumber("2"))
+	f.Dictionary.set([]uint64{32769, 3, 5, createdBy.Index, 11}, encodeLengthPrefixed(notes.Index))
+	if err := f.readTables(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.readRelationships(); err != nil {
+		t.Fatal(err)
+	}
+
+	table = f.Table("Untitled")
+	calc := table.Column("Doubled")
+	if calc.Type != FmpFieldCalculation || calc.DataType != FmpDataNumber || calc.Context == nil || calc.Context.Name != "Untitled" {
+		t.Errorf("expected a number calculation in the context of Untitled, got %+v", calc)
+	}
+	if furigana := table.Column("CreatedBy").Furigana; furigana == nil || furigana.Name != "Notes" {
+		t.Errorf("expected Notes to be the furigana field of CreatedBy, got %+v", furigana)
+	}
+
+	record := table.Record(1)
+	for _, source := range []string{`"[" & Upper ( Untitled::Notes ) & "]"`, `Untitled::Setting`} {
+		node := parseSource(t, f, nil, source)
+		if !referencesEmpty(node, record) {
+			t.Errorf("expected %s to refer to empty fields only", source)
+		}
+	}
+	if err := record.Update(map[string]string{"Notes": "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.SetGlobal("Setting", "y"); err != nil {
+		t.Fatal(err)
+	}
+	for _, source := range []string{`"[" & Upper ( Untitled::Notes ) & "]"`, `Untitled::Setting`} {
+		node := parseSource(t, f, nil, source)
+		if referencesEmpty(node, record) {
+			t.Errorf("expected %s to refer to a field with a value", source)
+		}
+	}
+	if _, ok := record.Values[notes.Index]; !ok {
+		t.Errorf("expected Notes to be stored with the record")
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	f, err := OpenFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				table := f.Table("Untitled")
+				if table.Column("PrimaryKey") == nil {
+					t.Error("expected column to exist")
+					return
+				}
+				if table.Record(1).Value("PrimaryKey") != "629FAA83-50D8-401F-A560-C8D45217D17B" {
+					t.Error("expected first record to keep its primary key")
+					return
+				}
+				if decodeString(f.DictionaryValue(table.ID, 5, 1, 1)) != "629FAA83-50D8-401F-A560-C8D45217D17B" {
+					t.Error("expected dictionary to keep the primary key")
+					return
+				}
+				for _, record := range table.AllRecords() {
+					record.Value("CreatedBy")
+				}
+				Verify(f)
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		record := f.Table("Untitled").Record(2)
+		for j := range 25 {
+			if err := record.Update(map[string]string{"CreatedBy": strconv.Itoa(j)}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			table := f.Table("Untitled")
+			for j := range 25 {
+				_, err := table.NewRecord(map[string]string{"PrimaryKey": fmt.Sprintf("%d-%d", i, j)})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(f.Table("Untitled").AllRecords()); n != 103 {
+		t.Errorf("expected 103 records, got %d", n)
+	}
+}
+
+func TestTruncatedChunks(t *testing.T) {
+	chunks := [][]byte{
+		{0x00, 0x01},
+		{0x03, 0x10, 1, 2, 3, 4},
+		{0x06, 0x10, 2, 1, 2},
+		{0x0E, 0x00, 0x80, 2, 1, 2},
+		{0x0E, 0xFF, 1, 2, 3, 4, 5},
+		{0x16, 0, 0, 1, 2, 1, 2},
+		{0x17, 0, 0, 1, 0, 2, 1, 2},
+		{0x19, 1, 1, 2},
+		{0x1E, 1, 7, 2, 1, 2},
+		{0x1F, 1, 7, 0, 2, 1, 2},
+		{0x20, 0xFE, 1, 2, 3, 4, 5, 6, 7, 8},
+		{0x23, 1, 1},
+		{0x30, 0, 0, 1},
+		{0x38, 2, 1, 2},
+	}
+	sect := &FmpSector{}
+	for _, full := range chunks {
+		if chunk, err := sect.readChunk(full); err != nil || chunk.Length != uint64(len(full)) {
+			t.Errorf("expected chunk % x to be read whole, got %v", full, err)
+		}
+		for n := range len(full) {
+			if _, err := sect.readChunk(full[:n]); !errors.Is(err, ErrBadChunk) {
+				t.Errorf("expected ErrBadChunk for % x, got %v", full[:n], err)
+			}
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	path := corruptCopy(t, 53*sectorSize+sectorHeaderSize, 0x18)
+
+	_, err := OpenFile(path)
+	if !errors.Is(err, ErrBadChunk) {
+		t.Fatalf("expected ErrBadChunk, got %v", err)
+	}
+
+	var perr *FmpParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected a FmpParseError, got %T", err)
+	}
+	if perr.SectorID != 53 {
+		t.Errorf("expected error in sector 53, got %d", perr.SectorID)
+	}
+	if perr.Offset != 53*sectorSize+sectorHeaderSize {
+		t.Errorf("expected error at offset %d, got %d", 53*sectorSize+sectorHeaderSize, perr.Offset)
+	}
+	if !perr.HasOpcode || perr.Opcode != 0x18 {
+		t.Errorf("expected opcode 0x18, got 0x%02x", perr.Opcode)
+	}
+}
+
+func TestSalvage(t *testing.T) {
+	path := corruptCopy(t, 53*sectorSize+sectorHeaderSize, 0x18)
+
+	f, err := OpenFileWithOptions(path, &FmpOpenOptions{Salvage: true})
+	if err != nil {
+		t.Fatalf("expected salvage to succeed, got %v", err)
+	}
+	defer f.Close()
+
+	if len(f.Problems) != 1 || !errors.Is(f.Problems[0], ErrBadChunk) {
+		t.Errorf("expected a single bad chunk problem, got %v", f.Problems)
+	}
+	if len(f.Sectors) != 54 {
+		t.Errorf("expected decoding to resume at the next sector, but read %d sectors", len(f.Sectors))
+	}
+	if table := f.Table("Untitled"); table == nil || len(table.Records) != 3 {
+		t.Errorf("expected table data to survive")
+	}
+
+	// Broken links resume at the next sector that has not been read.
+	for _, c := range []struct {
+		offset  int64
+		value   byte
+		err     error
+		sectors int
+	}{
+		{2*sectorSize + 7, 0x01, ErrBadSectorHeader, 53},
+		{53*sectorSize + 10, 0x01, ErrBadHeader, 54},
+		{7*sectorSize + 11, 47, ErrSectorLoop, 54},
+	} {
+		f, err := OpenFileWithOptions(corruptCopy(t, c.offset, c.value), &FmpOpenOptions{Salvage: true})
+		if err != nil {
+			t.Fatalf("expected salvage to succeed, got %v", err)
+		}
+		if len(f.Problems) != 1 || !errors.Is(f.Problems[0], c.err) {
+			t.Errorf("expected a single %v problem, got %v", c.err, f.Problems)
+		}
+		if len(f.Sectors) != c.sectors {
+			t.Errorf("expected %d sectors after a %v, got %d", c.sectors, c.err, len(f.Sectors))
+		}
+		if table := f.Table("Untitled"); table == nil || len(table.Records) != 3 {
+			t.Errorf("expected table data to survive a %v", c.err)
+		}
+		f.Close()
+	}
+}
+
+func TestVerify(t *testing.T) {
+	f, err := OpenFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if report := Verify(f); !report.Clean() {
+		t.Errorf("expected sample file to be clean, got %v", report.Findings)
+	}
+
+	damaged, err := OpenFileWithOptions(corruptCopy(t, 53*sectorSize+sectorHeaderSize, 0x18), &FmpOpenOptions{Salvage: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer damaged.Close()
+
+	report := Verify(damaged)
+	if report.Worst() != FmpSeverityError {
+		t.Errorf("expected damaged file to have errors, got %v", report.Findings)
+	}
+	if report.Findings[0].Check != "chunks" {
+		t.Errorf("expected a chunk finding, got %v", report.Findings[0])
+	}
+	if again := Verify(damaged); !reflect.DeepEqual(again.Findings, report.Findings) {
+		t.Errorf("expected findings in the same order, got %v and %v", report.Findings, again.Findings)
+	}
+
+	// Chunks are checked as they are on disk, also when not salvaging.
+	path := corruptCopy(t, 0, 0x00)
+	changed, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer changed.Close()
+	if _, err := changed.stream.WriteAt([]byte{0x18}, 53*sectorSize+sectorHeaderSize); err != nil {
+		t.Fatal(err)
+	}
+	if report := Verify(changed); report.Clean() || report.Findings[0].Check != "chunks" || !errors.Is(report.Findings[0].Err, ErrBadChunk) {
+		t.Errorf("expected a chunk finding, got %v", report.Findings)
+	}
+}
+
+func TestRelationships(t *testing.T) {
+	f, err := OpenFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	occurrences := f.TableOccurrences()
+	if len(occurrences) != 1 {
+		t.Fatalf("expected 1 table occurrence, got %d", len(occurrences))
+	}
+	if occurrences[0].Name != "Untitled" || occurrences[0].Table != f.Table("Untitled") {
+		t.Errorf("expected occurrence 'Untitled' of table 'Untitled', got '%s'", occurrences[0].Name)
+	}
+	if len(f.Relationships()) != 0 {
+		t.Errorf("expected no relationships, got %d", len(f.Relationships()))
+	}
+
+	if occurrences[0].GraphData != nil {
+		t.Errorf("expected the occurrence of the sample to have no graph data, got % x", occurrences[0].GraphData)
+	}
+
+	addSelfJoin(t, f, FmpRelationEqual, 3, 3)
+	f.Dictionary.set([]uint64{3, 17, 5, 0, 1, 2}, []byte{0xD0, 0x00, 0x01, 0xD0, 0x00, 0x02, 0x05})
+	if err := f.readRelationships(); err != nil {
+		t.Fatal(err)
+	}
+
+	rels := f.Relationships()
+	if len(rels) != 1 {
+		t.Fatalf("expected 1 relationship, got %d", len(rels))
+	}
+	rel := rels[0]
+	if rel.ID != 1 || rel.Left.Name != "Untitled" || rel.Right.Name != "Creator" || rel.Right.Table != f.Table("Untitled") {
+		t.Errorf("expected relationship between 'Untitled' and 'Creator', got '%s' and '%s'", rel.Left.Name, rel.Right.Name)
+	}
+	if len(rel.Predicates) != 1 || rel.Predicates[0].Left.Name != "CreatedBy" || rel.Predicates[0].Right.Name != "CreatedBy" {
+		t.Errorf("expected a join on CreatedBy, got %+v", rel.Predicates)
+	}
+	if !rel.CascadeCreateLeft || rel.CascadeDeleteLeft || !rel.CascadeCreateRight || rel.CascadeDeleteRight {
+		t.Errorf("expected creation to cascade both ways, got %+v", rel)
+	}
+
+	f.Dictionary.set([]uint64{3, 17, 5, 0, 1, 3, 2}, []byte{byte(FmpRelationEqual), 0, 3, 0, 99})
+	if err := f.readRelationships(); !errors.Is(err, ErrBadDictionary) {
+		t.Errorf("expected ErrBadDictionary for a predicate on an unknown field, got %v", err)
+	}
+}
+
+// addSelfJoin adds an occurrence named Creator of the sample table, stored
+// like the Untitled occurrence, and a relationship from Untitled to it on the
+// given fields.
+func addSelfJoin(t *testing.T, f *FmpFile, operator FmpRelationOperator, left, right uint64) {
+	f.Dictionary.set([]uint64{3, 17, 5, 13631490, 2}, f.Dictionary.GetValue(3, 17, 5, 13631489, 2))
+	f.Dictionary.set([]uint64{3, 17, 5, 13631490, 16}, encodeString("Creator"))
+	f.Dictionary.set([]uint64{3, 17, 5, 0, 1, 2}, []byte{0xD0, 0x00, 0x01, 0xD0, 0x00, 0x02, 0x00})
+	f.Dictionary.set([]uint64{3, 17, 5, 0, 1, 3, 1}, []byte{byte(operator), byte(left >> 8), byte(left), byte(right >> 8), byte(right)})
+	if err := f.readRelationships(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRelated(t *testing.T) {
+	f, err := OpenFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	table := f.Table("Untitled")
+	addSelfJoin(t, f, FmpRelationLess, 2, 2)
+
+	related := slices.Collect(table.Record(1).Related("Creator"))
+	if len(related) != 2 || related[0].Index != 2 || related[1].Index != 3 {
+		t.Errorf("expected records created later to be related, got %v", related)
+	}
+
+	related = slices.Collect(table.Record(3).Related("Untitled"))
+	if len(related) != 2 || related[0].Index != 1 || related[1].Index != 2 {
+		t.Errorf("expected records created earlier to be related in reverse, got %v", related)
+	}
+
+	if n := len(slices.Collect(table.Record(1).Related("Missing"))); n != 0 {
+		t.Errorf("expected no records for unknown occurrence, got %d", n)
+	}
+
+	// A second predicate read from the file narrows the related records.
+	f.Dictionary.set([]uint64{3, 17, 5, 0, 1, 3, 2}, []byte{byte(FmpRelationNotEqual), 0, 1, 0, 1})
+	f.Dictionary.set([]uint64{32769, 5, 2, 1}, f.Dictionary.GetValue(32769, 5, 1, 1))
+	if err := f.readTables(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.readRelationships(); err != nil {
+		t.Fatal(err)
+	}
+	table = f.Table("Untitled")
+	related = slices.Collect(table.Record(1).Related("Creator"))
+	if len(related) != 1 || related[0].Index != 3 {
+		t.Errorf("expected only record 3 to match both predicates, got %v", related)
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	f, err := OpenFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tx := f.Begin()
+	setting, _ := tx.NewColumn(f.Table("Untitled"), "Setting", FmpDataText)
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	flags := make([]byte, 26)
+	flags[0], flags[1], flags[9], flags[25] = byte(FmpFieldSimple), byte(FmpDataText), byte(FmpFieldStorageGlobal), 1
+	f.Dictionary.set([]uint64{32769, 3, 5, setting.Index, 2}, flags)
+	if err := f.readTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	table := f.Table("Untitled")
+	if table.Column("Setting").StorageType != FmpFieldStorageGlobal || table.Global("Setting") != "" {
+		t.Fatalf("expected an empty global field, got '%s'", table.Global("Setting"))
+	}
+
+	if err := table.SetGlobal("Setting", "light"); err != nil {
+		t.Fatal(err)
+	}
+	if table.Global("Setting") != "light" || table.Record(1).Value("Setting") != "light" {
+		t.Errorf("expected global value to be changed to 'light'")
+	}
+
+	record := table.Record(2)
+	if err := record.Update(map[string]string{"Setting": "dark"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := record.Values[setting.Index]; ok || table.Record(1).Value("Setting") != "dark" {
+		t.Errorf("expected update to set the global value instead of a record value")
+	}
+	created, err := table.NewRecord(map[string]string{"PrimaryKey": "G", "Setting": "dim"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := created.Values[setting.Index]; ok || table.Global("Setting") != "dim" {
+		t.Errorf("expected new record to set the global value instead of a record value")
+	}
+	if _, err := table.NewRecord(map[string]string{"PrimaryKey": "G", "Setting": "bright"}); !errors.Is(err, ErrValidation) || table.Global("Setting") != "dim" {
+		t.Errorf("expected a record failing validation to leave the global value, got %v", err)
+	}
+
+	if err := f.readRelationships(); err != nil {
+		t.Fatal(err)
+	}
+	calc, err := f.ParseCalculation(`Untitled::Setting & "!"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := calc.Eval(nil); err != nil || v != "dim!" {
+		t.Errorf("expected global to be evaluated without a record, got '%s' (%v)", v, err)
+	}
+
+	if err := table.SetGlobal("PrimaryKey", "x"); !errors.Is(err, ErrNotGlobal) {
+		t.Errorf("expected ErrNotGlobal, got %v", err)
+	}
+
+	// The value is kept in the field definition, and read from there.
+	if value := decodeString(f.Dictionary.GetValue(32769, 3, 5, setting.Index, 10)); value != "dim" {
+		t.Errorf("expected global value 'dim' to be stored, got '%s'", value)
+	}
+	f.Dictionary.set([]uint64{32769, 3, 5, setting.Index, 10}, encodeString("stored"))
+	if table.Global("Setting") != "stored" {
+		t.Errorf("expected stored global value, got '%s'", table.Global("Setting"))
+	}
+
+	// Global values are validated like other values.
+	setValidation(t, f, setting.Index, 0x02, 0, map[uint64][]byte{1: encodeLengthPrefixed(4)})
+	table = f.Table("Untitled")
+	if err := table.SetGlobal("Setting", "bright"); !errors.Is(err, ErrValidation) || table.Global("Setting") != "stored" {
+		t.Errorf("expected a global value failing validation to be rejected, got %v", err)
+	}
+	if err := table.Record(1).Update(map[string]string{"Setting": "bright"}); !errors.Is(err, ErrValidation) {
+		t.Errorf("expected an update failing validation of a global to be rejected, got %v", err)
+	}
+	if err := table.SetGlobal("Setting", "dusk"); err != nil || table.Global("Setting") != "dusk" {
+		t.Errorf("expected global value 'dusk', got '%s' (%v)", table.Global("Setting"), err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	f, err := OpenFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	flags := make([]byte, 26)
+	if decodeLookup(flags, &FmpDict{}) != nil {
+		t.Errorf("expected no lookup without flags")
+	}
+	flags[10] = 0x04
+	if lookup := decodeLookup(flags, &FmpDict{}); lookup == nil || lookup.Active {
+		t.Errorf("expected an inactive lookup, got %+v", lookup)
+	}
+	flags[11] = 0x80
+	if lookup := decodeLookup(flags, &FmpDict{}); lookup == nil || !lookup.Active {
+		t.Errorf("expected an active lookup, got %+v", lookup)
+	}
+
+	tx := f.Begin()
+	ref, _ := tx.NewColumn(f.Table("Untitled"), "Ref", FmpDataText)
+	copied, _ := tx.NewColumn(f.Table("Untitled"), "Copied", FmpDataText)
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lookup copies the creation timestamp through the Creator
+	// occurrence, or the next lower one if no record matches.
+	path := []uint64{32769, 3, 5, copied.Index}
+	flags = slices.Clone(f.Dictionary.GetValue(append(path, 2)...))
+	flags[10] = 0x04
+	f.Dictionary.set(append(path, 2), flags)
+	f.Dictionary.set(append(path, 7, 1), append(encodeLengthPrefixed(13631490), encodeLengthPrefixed(2)...))
+	f.Dictionary.set(append(path, 7, 2), []byte{byte(FmpLookupNextLower), 0x01})
+	f.Dictionary.set(append(path, 7, 3), encodeString("none"))
+	if err := f.readTables(); err != nil {
+		t.Fatal(err)
+	}
+	addSelfJoin(t, f, FmpRelationEqual, ref.Index, 1)
+
+	table := f.Table("Untitled")
+	copied = table.Column("Copied")
+	lookup := copied.Lookup
+	if lookup == nil || lookup.Source.String() != "Creator::CreationTimestamp" || lookup.NoMatch != FmpLookupNextLower || !lookup.SkipEmpty || lookup.Value != "none" {
+		t.Fatalf("unexpected lookup %+v", lookup)
+	}
+
+	record, source := table.Record(1), table.Record(2)
+	if err := record.Relookup("Copied"); !errors.Is(err, ErrNoLookup) {
+		t.Errorf("expected ErrNoLookup for an inactive lookup, got %v", err)
+	}
+
+	copied.Lookup.Active = true
+	for _, key := range []string{source.Value("PrimaryKey"), source.Value("PrimaryKey") + "0"} {
+		if err := record.Update(map[string]string{"Ref": key}); err != nil {
+			t.Fatal(err)
+		}
+		if err := record.Relookup("Copied"); err != nil {
+			t.Fatal(err)
+		}
+		if record.Value("Copied") != source.Value("CreationTimestamp") {
+			t.Errorf("expected '%s' to look up '%s', got '%s'", key, source.Value("CreationTimestamp"), record.Value("Copied"))
+		}
+	}
+
+	copied.Lookup.NoMatch = FmpLookupUseValue
+	if err := record.Update(map[string]string{"Ref": "unrelated"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := record.Relookup("Copied"); err != nil || record.Value("Copied") != "none" {
+		t.Errorf("expected the value for no match, got '%s' (%v)", record.Value("Copied"), err)
+	}
+
+	if err := record.Relookup("Ref"); !errors.Is(err, ErrNoLookup) {
+		t.Errorf("expected ErrNoLookup, got %v", err)
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	f, err := OpenFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	table := f.Table("Untitled")
+	if table.Column("PrimaryKey").Language != FmpLanguageUnicode || table.Column("CreatedBy").Language.String() != "Dutch" {
+		t.Errorf("expected languages Unicode and Dutch, got %s and %s", table.Column("PrimaryKey").Language, table.Column("CreatedBy").Language)
+	}
+
+	for _, tc := range []struct {
+		language FmpLanguage
+		a, b     string
+		expected int
+	}{
+		{FmpLanguageEnglish, "Öl", "zebra", -1},
+		{FmpLanguageSwedish, "Öl", "zebra", 1},
+		{FmpLanguageSwedish, "vas", "wal", 1},
+		{FmpLanguageSwedishVW, "vas", "wal", -1},
+		{FmpLanguageGerman, "Mädchen", "Madrid", 1},
+		{FmpLanguageGermanA, "Mädchen", "Madrid", -1},
+		{FmpLanguageSpanish, "chico", "cuna", 1},
+		{FmpLanguageSpanishModern, "chico", "cuna", -1},
+		{FmpLanguageCzech, "chata", "hrad", 1},
+		{FmpLanguageDutch, "apple", "Apple", 0},
+		{FmpLanguageDutch, "cafe", "café", -1},
+		{FmpLanguageUnicode, "apple", "Banana", 1},
+		{FmpLanguageJapanese, "かた", "ガス", 1},
+		{FmpLanguageJapanese, "ｶﾒﾗ", "かめる", -1},
+		{FmpLanguageJapanese, "カード", "かとう", -1},
+		{FmpLanguageJapanese, "きゃく", "きやま", -1},
+		{FmpLanguageJapanese, "がっこう", "かつこう", 1},
+		{FmpLanguageJapanese, "ＡＢＣ", "abd", -1},
+		{FmpLanguageJapanese, "漢字", "かんじ", 1},
+		{FmpLanguageJapanese, "tokyo", "とうきょう", -1},
+	} {
+		if c := tc.language.Compare(tc.a, tc.b); c != tc.expected {
+			t.Errorf("expected %s to compare '%s' and '%s' as %d, got %d", tc.language, tc.a, tc.b, tc.expected, c)
+		}
+	}
+
+	records := table.AllRecords()
+	keys := []string{}
+	for _, record := range records {
+		keys = append(keys, record.Value("PrimaryKey"))
+	}
+	slices.Sort(keys)
+	slices.Reverse(keys)
+	table.SortRecords(records, FmpSortField{Field: FmpFieldRef{Column: table.Column("PrimaryKey")}, Descending: true})
+	for i, record := range records {
+		if record.Value("PrimaryKey") != keys[i] {
+			t.Errorf("expected record %d to have key '%s', got '%s'", i, keys[i], record.Value("PrimaryKey"))
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	f, err := OpenFileWithOptions("../files/Untitled.fmp12", &FmpOpenOptions{NoAutoEnter: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tx := f.Begin()
+	amount, _ := tx.NewColumn(f.Table("Untitled"), "Amount", FmpDataNumber)
+	group, _ := tx.NewColumn(f.Table("Untitled"), "Group", FmpDataText)
+	total, _ := tx.NewColumn(f.Table("Untitled"), "Total", FmpDataNumber)
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for i, record := range f.Table("Untitled").AllRecords() {
+		if err := record.Update(map[string]string{"Amount": strconv.Itoa(i + 1), "Group": string(rune('a' + i/2))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	flags := make([]byte, 26)
+	if decodeSummary(flags, &FmpDict{}) != nil {
+		t.Errorf("expected simple fields to have no summary")
+	}
+	flags[0] = byte(FmpFieldSummary)
+	for code, operation := range map[byte]FmpSummaryOperation{1: FmpSummaryList, 2: FmpSummaryTotal, 5: FmpSummaryAverage} {
+		flags[1] = code
+		if summary := decodeSummary(flags, &FmpDict{}); summary == nil || summary.Operation != operation {
+			t.Errorf("expected code %d to decode as %s, got %+v", code, operation, summary)
+		}
+	}
+
+	// A running count of Amount, by Group, read from the file.
+	path := []uint64{32769, 3, 5, total.Index}
+	flags = slices.Clone(f.Dictionary.GetValue(append(path, 2)...))
+	flags[0], flags[1] = byte(FmpFieldSummary), 2
+	f.Dictionary.set(append(path, 2), flags)
+	f.Dictionary.set(append(path, 8, 1), encodeLengthPrefixed(amount.Index))
+	f.Dictionary.set(append(path, 8, 2), []byte{1, 0x01})
+	f.Dictionary.set(append(path, 8, 4), encodeLengthPrefixed(group.Index))
+	if err := f.readTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	table := f.Table("Untitled")
+	amount, group, total = table.Column("Amount"), table.Column("Group"), table.Column("Total")
+	if s := total.Summary; s == nil || s.Operation != FmpSummaryCount || !s.Running || s.Population || s.Field != amount || s.Weight != nil || s.BreakField != group || total.DataType != FmpDataNumber {
+		t.Errorf("unexpected summary %+v of type %d", s, total.DataType)
+	}
+	if values, err := table.Summarize("Total", table.AllRecords()); err != nil || !slices.Equal(values, []string{"1", "2", "1"}) {
+		t.Errorf("expected running count by group, got %q (%v)", values, err)
+	}
+
+	records := table.AllRecords()
+	for _, tc := range []struct {
+		operation FmpSummaryOperation
+		running   bool
+		breaks    bool
+		expected  []string
+	}{
+		{FmpSummaryTotal, true, true, []string{"1", "3", "3"}},
+		{FmpSummaryTotal, false, false, []string{"6", "6", "6"}},
+		{FmpSummaryCount, true, false, []string{"1", "2", "3"}},
+		{FmpSummaryMaximum, false, true, []string{"2", "2", "3"}},
+		{FmpSummaryStdDev, false, false, []string{"1", "1", "1"}},
+		{FmpSummaryFractionOfTotal, false, true, []string{"0.3333333333333333", "0.6666666666666666", "1"}},
+		{FmpSummaryList, false, false, []string{"1\r2\r3", "1\r2\r3", "1\r2\r3"}},
+	} {
+		total.Summary = &FmpSummary{Operation: tc.operation, Field: amount, Running: tc.running}
+		if tc.breaks {
+			total.Summary.BreakField = group
+		}
+		values, err := table.Summarize("Total", records)
+		if err != nil || !slices.Equal(values, tc.expected) {
+			t.Errorf("expected %s to give %q, got %q (%v)", tc.operation, tc.expected, values, err)
+		}
+	}
+
+	if _, err := table.Summarize("Amount", records); !errors.Is(err, ErrNoSummary) {
+		t.Errorf("expected ErrNoSummary, got %v", err)
+	}
+}
+
+func TestScripts(t *testing.T) {
+	f, err := OpenFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if n := len(f.Scripts()); n != 0 {
+		t.Errorf("expected no scripts in sample file, got %d", n)
+	}
+
+	addScript(t, f)
+
+	scripts := f.Scripts()
+	if len(scripts) != 1 {
+		t.Fatalf("expected 1 script, got %d", len(scripts))
+	}
+	script := scripts[0]
+	if script.ID != 2 || script.Name != "Greet" {
+		t.Errorf("expected script 'Greet', got '%s'", script.Name)
+	}
+	if script.Folder != "Utilities" {
+		t.Errorf("expected script to be in folder 'Utilities', got '%s'", script.Folder)
+	}
+
+	indexes := []uint64{}
+	for _, step := range script.Steps {
+		indexes = append(indexes, step.Index)
+	}
+	if !slices.Equal(indexes, []uint64{1, 2, 3}) {
+		t.Errorf("expected steps 1, 2 and 3, got %v", indexes)
+	}
+	types := []FmpScriptStepType{}
+	for _, step := range script.Steps {
+		types = append(types, step.Type)
+	}
+	if !slices.Equal(types, []FmpScriptStepType{FmpScriptSetVariable, FmpScriptBeep, FmpScriptBeep}) {
+		t.Errorf("expected Set Variable and two Beep steps, got %v", types)
+	}
+	if script.Steps[0].Disabled || !script.Steps[1].Disabled || script.Steps[2].Disabled {
+		t.Errorf("expected only the second step to be disabled")
+	}
+
+	setVar, ok := script.Steps[0].Params.(*FmpSetVariableParams)
+	if !ok || setVar.Name != "$greeting" || setVar.Value.String() != "42" {
+		t.Errorf("expected Set Variable parameters for $greeting, got %#v", script.Steps[0].Params)
+	}
+	if script.Steps[1].Params != nil {
+		t.Errorf("expected Beep to have no parameters, got %#v", script.Steps[1].Params)
+	}
+
+	lines := strings.Split(script.Format(), "\n")
+	if lines[0] != "Set Variable [ $greeting ; Value: 42 ]" {
+		t.Errorf("expected Set Variable step, got '%s'", lines[0])
+	}
+	if lines[1] != "// Beep" {
+		t.Errorf("expected disabled Beep step, got '%s'", lines[1])
+	}
+	if lines[2] != "Beep" {
+		t.Errorf("expected Beep step, got '%s'", lines[2])
+	}
+	if got := (&FmpScriptStep{Type: 999}).Format(); got != "Unknown step 999" {
+		t.Errorf("expected unknown step, got '%s'", got)
+	}
+	if got := (&FmpScriptStep{Type: FmpScriptBlankLineComment}).Format(); got != "Blank Line/Comment" {
+		t.Errorf("expected a comment without decoded text to be rendered by name, got '%s'", got)
+	}
+
+	f.Dictionary.set([]uint64{17, 5, 2, 4}, make([]byte, scriptStepSize+1))
+	if err := f.readScripts(); err == nil {
+		t.Errorf("expected an error for a step record of the wrong size")
+	}
+}
+
+// addScript adds a script named Greet to a folder named Utilities. The script
+// sets $greeting to 42 and beeps, with a disabled Beep step in between.
+func addScript(t *testing.T, f *FmpFile) {
+	f.Dictionary.set([]uint64{17, 1, 7, 1, 2}, []byte{0x01})
+	f.Dictionary.set([]uint64{17, 1, 7, 1, 16}, encodeString("Utilities"))
+	f.Dictionary.set([]uint64{17, 1, 7, 2, 4}, []byte{1, 1})
+	f.Dictionary.set([]uint64{17, 1, 7, 2, 16}, encodeString("Greet"))
+
+	f.Dictionary.set([]uint64{17, 5, 2, 4}, scriptCode(
+		FmpScriptSetVariable,
+		FmpScriptCommentedOut, FmpScriptBeep,
+		FmpScriptBeep,
+	))
+	f.Dictionary.set([]uint64{17, 5, 2, 5, 1, 128, 1}, encodeString("$greeting"))
+	f.Dictionary.set([]uint64{17, 5, 2, 5, 1, 129, 5, 5}, calcNumber("42"))
+
+	if err := f.readScripts(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScriptStepParams(t *testing.T) {
+	f, err := OpenFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ref := append(encodeLengthPrefixed(13631489), encodeLengthPrefixed(1)...)
+	step := func(index uint64, path ...uint64) []uint64 {
+		return append([]uint64{17, 5, 3, 5, index}, path...)
+	}
+	f.Dictionary.set([]uint64{17, 1, 7, 3, 16}, encodeString("Steps"))
+	f.Dictionary.set([]uint64{17, 5, 3, 4}, scriptCode(
+		FmpScriptSetField, FmpScriptPerformScript, FmpScriptGoToLayout, FmpScriptGoToLayout,
+		FmpScriptIf, FmpScriptAllowUserAbort, FmpScriptShowCustomDialog, FmpScriptEndIf,
+		FmpScriptSortRecords, FmpScriptPerformFind,
+	))
+	f.Dictionary.set(step(1, 130), ref)
+	f.Dictionary.set(step(1, 129, 5, 5), calcNumber("42"))
+	f.Dictionary.set(step(2, 131), encodeLengthPrefixed(2))
+	f.Dictionary.set(step(2, 129, 5, 5), calcVariable("$greeting"))
+	f.Dictionary.set(step(3, 2), []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 1})
+	f.Dictionary.set(step(4, 2), make([]byte, 10))
+	f.Dictionary.set(step(5, 129, 5, 5), calcVariable("$greeting"))
+	opts := make([]byte, 27)
+	opts[26] = 3
+	f.Dictionary.set(step(6, 2), opts)
+	f.Dictionary.set(step(7, 132, 5, 5), calcNumber("1"))
+	f.Dictionary.set(step(7, 129, 5, 5), calcVariable("$greeting"))
+	f.Dictionary.set(step(7, 133, 1, 16), encodeString("OK"))
+	f.Dictionary.set(step(7, 133, 2, 16), encodeString("Cancel"))
+	f.Dictionary.set(step(9, 134, 1), append(slices.Clone(ref), 1))
+	f.Dictionary.set(step(10, 135, 1), []byte{0})
+	f.Dictionary.set(step(10, 135, 1, 1), append(slices.Clone(ref), encodeString("abc")...))
+	f.Dictionary.set(step(10, 135, 2), []byte{1})
+	addScript(t, f)
+
+	script := f.Script("Steps")
+	if script == nil || len(script.Steps) != 10 {
+		t.Fatalf("expected script 'Steps' with 10 steps, got %+v", script)
+	}
+	steps := script.Steps
+
+	setField, ok := steps[0].Params.(*FmpSetFieldParams)
+	if !ok || setField.Field.String() != "Untitled::PrimaryKey" || setField.Value.String() != "42" {
+		t.Errorf("expected Set Field parameters for Untitled::PrimaryKey, got %#v", steps[0].Params)
+	}
+	perform, ok := steps[1].Params.(*FmpPerformScriptParams)
+	if !ok || perform.Script == nil || perform.Script.Name != "Greet" || perform.Parameter.String() != "$greeting" {
+		t.Errorf("expected Perform Script parameters for Greet, got %#v", steps[1].Params)
+	}
+	if layout, ok := steps[2].Params.(*FmpGoToLayoutParams); !ok || layout.LayoutID != 1 || layout.Original {
+		t.Errorf("expected Go to Layout 1, got %#v", steps[2].Params)
+	}
+	if layout, ok := steps[3].Params.(*FmpGoToLayoutParams); !ok || !layout.Original {
+		t.Errorf("expected Go to Layout original layout, got %#v", steps[3].Params)
+	}
+	if cond, ok := steps[4].Params.(*FmpConditionParams); !ok || cond.Condition.String() != "$greeting" {
+		t.Errorf("expected If to have a condition, got %#v", steps[4].Params)
+	}
+	if abort, ok := steps[5].Params.(*FmpAllowUserAbortParams); !ok || !abort.On {
+		t.Errorf("expected Allow User Abort to be on, got %#v", steps[5].Params)
+	}
+	dialog, ok := steps[6].Params.(*FmpShowCustomDialogParams)
+	if !ok || dialog.Title.String() != "1" || dialog.Message.String() != "$greeting" || !slices.Equal(dialog.Buttons, []string{"OK", "Cancel"}) {
+		t.Errorf("expected dialog with OK and Cancel buttons, got %#v", steps[6].Params)
+	}
+	if steps[7].Params != nil {
+		t.Errorf("expected End If to have no parameters, got %#v", steps[7].Params)
+	}
+	sort, ok := steps[8].Params.(*FmpSortParams)
+	if !ok || len(sort.Order) != 1 || sort.Order[0].Field.String() != "Untitled::PrimaryKey" || !sort.Order[0].Descending {
+		t.Errorf("expected descending sort by Untitled::PrimaryKey, got %#v", steps[8].Params)
+	}
+	find, ok := steps[9].Params.(*FmpFindParams)
+	if !ok || len(find.Requests) != 2 || find.Requests[0].Omit || !find.Requests[1].Omit {
+		t.Fatalf("expected a find request and an omit request, got %#v", steps[9].Params)
+	}
+	if crit := find.Requests[0].Criteria; len(crit) != 1 || crit[0].Field.String() != "Untitled::PrimaryKey" || crit[0].Value != "abc" {
+		t.Errorf("expected to find abc in Untitled::PrimaryKey, got %#v", crit)
+	}
+}
+
+func TestFormatScript(t *testing.T) {
+	f, err := OpenFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	step := func(index uint64, path ...uint64) []uint64 {
+		return append([]uint64{17, 5, 4, 5, index}, path...)
+	}
+	f.Dictionary.set([]uint64{17, 1, 7, 4, 16}, encodeString("Count"))
+	f.Dictionary.set([]uint64{17, 5, 4, 4}, scriptCode(
+		FmpScriptIf, FmpScriptLoop, FmpScriptSetVariable, FmpScriptCommentedOut, FmpScriptBeep,
+		FmpScriptExitLoopIf, FmpScriptEndLoop, FmpScriptElse, FmpScriptAllowUserAbort, FmpScriptEndIf,
+		FmpScriptGoToLayout,
+	))
+	f.Dictionary.set(step(1, 129, 5, 5), calcVariable("$go"))
+	f.Dictionary.set(step(3, 128, 1), encodeString("$i"))
+	f.Dictionary.set(step(3, 129, 5, 5), calcNumber("1"))
+	f.Dictionary.set(step(5, 129, 5, 5), calcVariable("$i"))
+	f.Dictionary.set(step(8, 2), make([]byte, 27))
+	f.Dictionary.set(step(10, 2), []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 1})
+	if err := f.readScripts(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		"If [ $go ]",
+		"    Loop",
+		"        Set Variable [ $i ; Value: 1 ]",
+		"        // Beep",
+		"        Exit Loop If [ $i ]",
+		"    End Loop",
+		"Else",
+		"    Allow User Abort [ Off ]",
+		"End If",
+		"Go to Layout [ layout 1 ]",
+		"",
+	}, "\n")
+	if got := f.Script("Count").Format(); got != expected {
+		t.Errorf("expected script\n%s\ngot\n%s", expected, got)
+	}
+}
+
+// scriptCode encodes step records of the given types, keyed from 1. A
+// FmpScriptCommentedOut type is combined with the type after it into a
+// disabled step.
+func scriptCode(types ...FmpScriptStepType) []byte {
+	code := []byte{}
+	for i := 0; i < len(types); i++ {
+		rec := make([]byte, scriptStepSize)
+		rec[0], rec[1] = 2, 1
+		rec[3] = byte(len(code)/scriptStepSize + 1)
+		rec[4], rec[5] = byte(types[i]>>8), byte(types[i])
+		if types[i] == FmpScriptCommentedOut {
+			i++
+			rec[6], rec[7] = byte(types[i]>>8), byte(types[i])
+		}
+		code = append(code, rec...)
+	}
+	return code
+}
+
+func TestLayouts(t *testing.T) {
+	f, err := OpenFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	layouts := f.Layouts()
+	if len(layouts) != 1 {
+		t.Fatalf("expected 1 layout, got %d", len(layouts))
+	}
+	layout := f.Layout("Untitled")
+	if layout == nil || layout.ID != 1 {
+		t.Fatalf("expected layout 'Untitled' with ID 1, got %+v", layout)
+	}
+	if layout.Occurrence == nil || layout.Occurrence.Name != "Untitled" {
+		t.Errorf("expected layout to show occurrence 'Untitled', got %+v", layout.Occurrence)
+	}
+	if layout.Width != 120 || layout.Height != 658 || len(layout.Parts) != 2 {
+		t.Fatalf("expected 2 parts and a size of 120x658, got %d and %vx%v", len(layout.Parts), layout.Width, layout.Height)
+	}
+	expected := []FmpLayoutPart{{FmpLayoutPartHeader, 0, 110}, {FmpLayoutPartBody, 110, 658}}
+	for i, part := range layout.Parts {
+		if *part != expected[i] {
+			t.Errorf("expected part %d to be %v, got %v", i, expected[i], *part)
+		}
+	}
+
+	// A catalog entry without a layout is a folder.
+	f.Dictionary.set([]uint64{4, 1, 7, 2, 16}, encodeString("Reports"))
+	f.Dictionary.set([]uint64{4, 1, 7, 1, 4}, []byte{1, 2})
+	addSelfJoin(t, f, FmpRelationEqual, 1, 1)
+	if err := f.readLayouts(); err != nil {
+		t.Fatal(err)
+	}
+	layouts = f.Layouts()
+	if len(layouts) != 1 || layouts[0].Folder != "Reports" {
+		t.Fatalf("expected layout 'Untitled' in folder 'Reports', got %+v", layouts)
+	}
+	if layouts[0].Occurrence != nil {
+		t.Errorf("expected no occurrence with several to choose from, got %+v", layouts[0].Occurrence)
+	}
+}
+
+func TestLayoutObjects(t *testing.T) {
+	f, err := OpenFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	layout := f.Layout("Untitled")
+	if len(layout.Objects) != 1 {
+		t.Fatalf("expected 1 object, got %d", len(layout.Objects))
+	}
+	object := layout.Objects[0]
+	if object.Type != 1 || object.Top != 24 || object.Left != 0 || object.Bottom != 40 || object.Right != 120 {
+		t.Errorf("unexpected object %+v", object)
+	}
+
+	var svg strings.Builder
+	if err := layout.RenderSVG(&svg); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`width="120" height="658"`, `<rect class="object" x="0" y="24" width="120" height="16" rx="0"/>`, ">Body<"} {
+		if !strings.Contains(svg.String(), s) {
+			t.Errorf("expected SVG to contain '%s'", s)
+		}
+	}
+
+	addSelfJoin(t, f, FmpRelationEqual, 1, 1)
+	data := slices.Clone(f.Dictionary.GetValue(4, 5, 1, 13))
+	for _, object := range [][]byte{
+		layoutObject(FmpLayoutObjectField, 120, 20, 140, 220, protoVarint(6, 13631489), protoVarint(7, 3)),
+		layoutObject(FmpLayoutObjectText, 120, 240, 140, 300, protoBytes(8, []byte("Created by"))),
+		layoutObject(FmpLayoutObjectButton, 160, 20, 180, 100, protoBytes(8, []byte("Save & Close"))),
+		layoutObject(FmpLayoutObjectPortal, 200, 20, 400, 480, protoVarint(6, 13631490)),
+		layoutObject(FmpLayoutObjectTabControl, 420, 20, 600, 480),
+		layoutObject(FmpLayoutObjectTabPanel, 420, 20, 440, 100, protoBytes(8, []byte("Notes"))),
+	} {
+		data = append(data, protoBytes(2, object)...)
+	}
+	f.Dictionary.set([]uint64{4, 5, 1, 13}, data)
+	if err := f.readLayouts(); err != nil {
+		t.Fatal(err)
+	}
+
+	layout = f.Layout("Untitled")
+	if len(layout.Objects) != 7 || layout.Width != 480 {
+		t.Fatalf("expected 7 objects and a width of 480, got %d and %v", len(layout.Objects), layout.Width)
+	}
+	field, label, button, portal, tabs, panel := layout.Objects[1], layout.Objects[2], layout.Objects[3], layout.Objects[4], layout.Objects[5], layout.Objects[6]
+	if field.Type != FmpLayoutObjectField || field.Field.String() != "Untitled::CreatedBy" || field.Occurrence.Name != "Untitled" || field.Left != 20 || field.Bottom != 140 {
+		t.Errorf("unexpected field object %+v", field)
+	}
+	if label.Type != FmpLayoutObjectText || label.Text != "Created by" || button.Type != FmpLayoutObjectButton || button.Text != "Save & Close" {
+		t.Errorf("unexpected text and button objects %+v, %+v", label, button)
+	}
+	if portal.Type != FmpLayoutObjectPortal || portal.Occurrence == nil || portal.Occurrence.Name != "Creator" || portal.Field.Column != nil {
+		t.Errorf("expected portal showing 'Creator', got %+v", portal)
+	}
+	if tabs.Type.String() != "Tab Control" || panel.Type != FmpLayoutObjectTabPanel || panel.Text != "Notes" {
+		t.Errorf("unexpected tab control and panel %+v, %+v", tabs, panel)
+	}
+
+	svg.Reset()
+	if err := layout.RenderSVG(&svg); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`width="480" height="658"`,
+		`class="field">Untitled::CreatedBy<`,
+		`class="text">Created by<`,
+		`<rect class="button" x="20" y="160" width="80" height="20" rx="4"/>`,
+		`class="button">Save &amp; Close<`,
+		`class="portal">Creator<`,
+		`<rect class="tab-control" x="20" y="420" width="460" height="180" rx="0"/>`,
+		`class="tab-panel">Notes<`,
+	} {
+		if !strings.Contains(svg.String(), s) {
+			t.Errorf("expected SVG to contain '%s'", s)
+		}
+	}
+}
+
+// layoutObject encodes a layout object of the given type and bounds, followed
+// by the given fields.
+func layoutObject(objectType FmpLayoutObjectType, top, left, bottom, right float64, fields ...[]byte) []byte {
+	bounds := slices.Concat(protoDouble(1, top), protoDouble(2, left), protoDouble(3, bottom), protoDouble(4, right))
+	return slices.Concat(append([][]byte{protoVarint(1, uint64(objectType)), protoBytes(4, bounds)}, fields...)...)
+}
+
+func protoVarint(num, value uint64) []byte {
+	return binary.AppendUvarint(binary.AppendUvarint(nil, num<<3), value)
+}
+
+func protoDouble(num uint64, value float64) []byte {
+	return binary.LittleEndian.AppendUint64(binary.AppendUvarint(nil, num<<3|1), math.Float64bits(value))
+}
+
+func protoBytes(num uint64, data []byte) []byte {
+	return append(binary.AppendUvarint(binary.AppendUvarint(nil, num<<3|2), uint64(len(data))), data...)
+}
+
+func TestValueLists(t *testing.T) {
+	f, err := OpenFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if len(f.ValueLists()) != 0 {
+		t.Errorf("expected no value lists, got %d", len(f.ValueLists()))
+	}
+
+	addSelfJoin(t, f, FmpRelationEqual, 1, 1)
+	table := f.Table("Untitled")
+	ref := func(occID, fieldID uint64) []byte {
+		return append(encodeLengthPrefixed(occID), encodeLengthPrefixed(fieldID)...)
+	}
+	untitled, creator := encodeLengthPrefixed(13631489), encodeLengthPrefixed(13631490)
+	lists := []map[uint64][]byte{
+		{16: encodeString("Answers"), 2: {byte(FmpValueListCustom), 0}, 5: encodeString("Yes\rNo")},
+		{16: encodeString("Created"), 2: {byte(FmpValueListField), 0x02}, 6: ref(13631489, 2), 7: ref(13631489, 3)},
+		{16: encodeString("Creators"), 2: {byte(FmpValueListField), 0x01}, 6: ref(13631489, 2), 7: ref(13631489, 3)},
+		{16: encodeString("Own key"), 2: {byte(FmpValueListField), 0}, 6: ref(13631490, 1), 8: untitled},
+		{16: encodeString("Backwards"), 2: {byte(FmpValueListField), 0}, 6: ref(13631490, 1), 8: creator},
+		{16: encodeString("Elsewhere"), 2: {byte(FmpValueListExternal), 0}, 9: encodeString("Other"), 10: encodeString("Colors")},
+		{16: encodeString("Unknown"), 2: {9, 0}},
+	}
+	for i, list := range lists {
+		for key, value := range list {
+			f.Dictionary.set([]uint64{33, 5, uint64(i + 1), key}, value)
+		}
+	}
+	if err := f.readValueLists(); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.ValueLists()) != 7 {
+		t.Errorf("expected 7 value lists, got %d", len(f.ValueLists()))
+	}
+	created := f.ValueList("Created")
+	if created.ID != 2 || created.Source != FmpValueListField || created.FirstField.String() != "Untitled::CreationTimestamp" || created.SecondField.String() != "Untitled::CreatedBy" || !created.SortBySecond || created.SecondFieldOnly {
+		t.Errorf("unexpected definition of 'Created': %+v", created)
+	}
+	if list := f.ValueList("Own key"); list.RelatedFrom == nil || list.RelatedFrom.Name != "Untitled" || list.FirstField.Occurrence.Name != "Creator" {
+		t.Errorf("expected related values from Untitled to Creator, got %+v", list)
+	}
+	if list := f.ValueList("Elsewhere"); list.ExternalFile != "Other" || list.ExternalList != "Colors" {
+		t.Errorf("expected values from 'Colors' in 'Other', got %+v", list)
+	}
+	if _, err := f.ValueList("Unknown").Values(nil); !errors.Is(err, ErrBadValueList) {
+		t.Errorf("expected ErrBadValueList, got %v", err)
+	}
+
+	answers, err := f.ValueList("Answers").Values(nil)
+	if err != nil || len(answers) != 2 || answers[0].Value != "Yes" || answers[1].Value != "No" {
+		t.Errorf("expected custom values Yes and No, got %v (%v)", answers, err)
+	}
+
+	items, err := f.ValueList("Created").Values(nil)
+	if err != nil || len(items) != 3 || items[0].Value != "16/06/2025 11:53:25" || items[0].Display != "16/06/2025 11:53:25\tAdmin" {
+		t.Errorf("expected 3 creation timestamps, got %v (%v)", items, err)
+	}
+	items, err = f.ValueList("Creators").Values(nil)
+	if err != nil || len(items) != 3 || items[0].Display != "Admin" {
+		t.Errorf("expected to show only the creators, got %v (%v)", items, err)
+	}
+
+	record := table.Record(1)
+	related, err := f.ValueList("Own key").Values(record)
+	if err != nil || len(related) != 1 || related[0].Value != record.Value("PrimaryKey") {
+		t.Errorf("expected only the related primary key, got %v (%v)", related, err)
+	}
+	related, err = f.ValueList("Backwards").Values(record)
+	if err != nil || len(related) != 0 {
+		t.Errorf("expected no values related from Creator, got %v (%v)", related, err)
+	}
+
+	if _, err := f.ValueList("Elsewhere").Values(nil); !errors.Is(err, ErrExternalSource) {
+		t.Errorf("expected ErrExternalSource, got %v", err)
+	}
+}
+
+func TestCalculation(t *testing.T) {
+	f, err := OpenFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	calc := f.Table("Untitled").Column("PrimaryKey").Calculation
+	if calc == nil || calc.String() != "Get ( UUID )" {
+		t.Fatalf("expected auto-enter calculation 'Get ( UUID )', got '%s'", calc)
+	}
+	if node, err := calc.AST(); err != nil || !reflect.DeepEqual(node, &FmpCalcGet{Name: "UUID"}) {
+		t.Errorf("expected Get ( UUID ), got %#v (%v)", node, err)
+	}
+
+	space := []byte{calcOpSpace, calcOpText, 1, 0x7a, 0}
+	code := slices.Concat(
+		[]byte{calcOpOpen}, calcNumber("2"), space, []byte{0x27}, space, calcVariable("$x"), []byte{calcOpClose},
+		space, []byte{0x50}, space, calcNumber("1.5"),
+	)
+	calc = f.decodeCalculation(code, nil)
+	if calc.String() != "(2 * $x) & 1.5" {
+		t.Errorf("expected '(2 * $x) & 1.5', got '%s'", calc)
+	}
+	expected := &FmpCalcBinary{
+		Op:    FmpCalcOperatorConcatenate,
+		Left:  &FmpCalcParen{Inner: &FmpCalcBinary{Op: FmpCalcOperatorMultiply, Left: &FmpCalcNumber{Value: "2"}, Right: &FmpCalcVariable{Name: "$x"}}},
+		Right: &FmpCalcNumber{Value: "1.5"},
+	}
+	if node, err := calc.AST(); err != nil || !reflect.DeepEqual(node, expected) {
+		t.Errorf("expected %s, got %v (%v)", expected, node, err)
+	}
+
+	calc = f.decodeCalculation([]byte{calcOpGet, calcOpOpen, calcOpGetName, 0x01, calcOpClose}, nil)
+	if node, err := calc.AST(); err != nil || calc.String() != "‹9b049c0105›" || !reflect.DeepEqual(node, &FmpCalcRaw{Bytes: calc.Bytecode}) {
+		t.Errorf("expected unknown bytecode to be kept, got '%s' (%v)", calc, err)
+	}
+	table := f.Table("Untitled")
+	code = slices.Concat(
+		calcFunction("Left"), []byte{calcOpOpen}, calcField(13631489, 3), []byte{calcOpSeparator}, space, calcNumber("2"), []byte{calcOpClose},
+		space, []byte{0x50}, space, calcText("a\r\"b"), space, []byte{0x50}, space, calcField(0, 3),
+	)
+	calc = f.decodeCalculation(code, table)
+	if calc.String() != `Left (Untitled::CreatedBy; 2) & "a¶\"b" & CreatedBy` {
+		t.Errorf("expected field references, text and a function call, got '%s'", calc)
+	}
+	expected = &FmpCalcBinary{
+		Op: FmpCalcOperatorConcatenate,
+		Left: &FmpCalcBinary{
+			Op: FmpCalcOperatorConcatenate,
+			Left: &FmpCalcCall{Name: "Left", Args: []FmpCalcNode{
+				&FmpCalcField{Ref: FmpFieldRef{Occurrence: f.occurrence("Untitled"), Column: table.Column("CreatedBy")}},
+				&FmpCalcNumber{Value: "2"},
+			}},
+			Right: &FmpCalcText{Value: "a\r\"b"},
+		},
+		Right: &FmpCalcField{Ref: FmpFieldRef{Column: table.Column("CreatedBy")}},
+	}
+	if node, err := calc.AST(); err != nil || !reflect.DeepEqual(node, expected) {
+		t.Errorf("expected %s, got %v (%v)", expected, node, err)
+	}
+	if _, err := f.decodeCalculation(calcField(13631489, 99), nil).AST(); !errors.Is(err, ErrBadCalculation) {
+		t.Errorf("expected an unknown field to be an error, got %v", err)
+	}
+	long := strings.Repeat("x", 300)
+	if calc := f.decodeCalculation(calcText(long), nil); calc.String() != `"`+long+`"` {
+		t.Errorf("expected text continued over chunks, got '%s'", calc)
+	}
+
+	for _, code := range [][]byte{{0x25}, {calcOpNumber, 0, 0}, {calcOpField, 1}} {
+		if _, err := f.decodeCalculation(code, nil).AST(); !errors.Is(err, ErrBadCalculation) {
+			t.Errorf("%x: expected ErrBadCalculation, got %v", code, err)
+		}
+	}
+}
+
+func TestParseCalculation(t *testing.T) {
+	f, err := OpenFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	table := f.Table("Untitled")
+	sample := table.Column("PrimaryKey").Calculation.Bytecode
+	for _, parse := range []func(string) (*FmpCalculation, error){f.ParseCalculation, table.ParseCalculation} {
+		calc, err := parse(table.Column("PrimaryKey").Calculation.String())
+		if err != nil || !slices.Equal(calc.Bytecode, sample) {
+			t.Errorf("expected parsing to round-trip to % x, got %v (%v)", sample, calc, err)
+		}
+	}
+
+	for src, expected := range map[string]string{
+		"(2 * $x) & 1.5":                         "(2 * $x) & 1.5",
+		"Get(UUID) &\r$$id":                      "Get (UUID) &\r$$id",
+		"-1 /* one */ + // two\r 2":              "-1  + \r 2",
+		`"a ¶ \"b\"" & ""`:                       `"a ¶ \"b\"" & ""`,
+		`Left(Untitled::CreatedBy;2)`:            `Left (Untitled::CreatedBy;2)`,
+		`$x = 1 AND not $y <> 2`:                 `$x = 1 and not $y ≠ 2`,
+		`Let ( [ x = 1 ; y = x ] ; x ^ y ≥ Pi )`: `Let ( [ x = 1 ; y = x ] ; x ^ y ≥ Pi )`,
+	} {
+		calc, err := f.ParseCalculation(src)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+		} else if calc.String() != expected {
+			t.Errorf("expected '%s', got '%s'", expected, calc.String())
+		}
+	}
+
+	var unsupported *FmpUnsupportedError
+	_, err = f.ParseCalculation(`Get ( CurrentDate )`)
+	if !errors.Is(err, ErrUnsupported) || !errors.As(err, &unsupported) || unsupported.Name != `Get ( CurrentDate )` {
+		t.Errorf("expected Get ( CurrentDate ) to be unsupported, got %v", err)
+	}
+	long := strings.Repeat("x", 300)
+	if calc, err := f.ParseCalculation(`"` + long + `"`); err != nil || !slices.Equal(calc.Bytecode, calcText(long)) {
+		t.Errorf("expected long text to be split into chunks, got %v (%v)", calc, err)
+	}
+
+	// Names of fields of the context table resolve to those fields, and
+	// otherwise to functions without arguments.
+	node := parseSource(t, f, table, `CreatedBy & Pi`)
+	expected := &FmpCalcBinary{Op: FmpCalcOperatorConcatenate, Left: &FmpCalcField{Ref: FmpFieldRef{Column: table.Column("CreatedBy")}}, Right: &FmpCalcCall{Name: "Pi"}}
+	if !reflect.DeepEqual(node, expected) || node.String() != "CreatedBy & Pi" {
+		t.Errorf("expected %s, got %s", expected, node)
+	}
+	if node := parseSource(t, f, nil, `CreatedBy`); !reflect.DeepEqual(node, &FmpCalcCall{Name: "CreatedBy"}) {
+		t.Errorf("expected a function without context, got %#v", node)
+	}
+	if v, err := evalCalcNode(parseSource(t, f, table, `Left ( CreatedBy ; 2 )`), &FmpEvalContext{Record: table.Record(1)}); err != nil || v != "Ad" {
+		t.Errorf("expected 'Ad', got '%s' (%v)", v, err)
+	}
+	if calc, err := table.ParseCalculation(`CreatedBy`); err != nil || !slices.Equal(calc.Bytecode, calcField(0, 3)) {
+		t.Errorf("expected a field of the context table, got %v (%v)", calc, err)
+	}
+	calc, err := table.ParseCalculation(`Let ( CreatedBy = 1 ; CreatedBy )`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node, err := calc.AST(); err != nil || node.(*FmpCalcLet).Body.String() != "CreatedBy" || reflect.TypeOf(node.(*FmpCalcLet).Body) != reflect.TypeOf(&FmpCalcVariable{}) {
+		t.Errorf("expected a variable of Let to hide the field, got %#v (%v)", node, err)
+	}
+
+	var syntaxErr *FmpCalcSyntaxError
+	for src, offset := range map[string]int{
+		`1 + `:                 4,
+		`"open`:                0,
+		`Untitled::Missing`:    0,
+		`If ( 1 ; 2 ; 3`:       14,
+		`Let ( x = 1 ; x ] )`:  16,
+		`Left ( "abc" ; 2 ) #`: 19,
+		`1.2.3`:                3,
+	} {
+		_, err := f.ParseCalculation(src)
+		if !errors.Is(err, ErrBadCalculation) || !errors.As(err, &syntaxErr) || syntaxErr.Offset != offset {
+			t.Errorf("%s: expected syntax error at offset %d, got %v", src, offset, err)
+		}
+	}
+}
+
+func TestCalculationEval(t *testing.T) {
+	f, err := OpenFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.readRelationships(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &FmpEvalContext{
+		Record:    f.Table("Untitled").Record(1),
+		Variables: map[string]string{"$x": "3"},
+		Now:       func() time.Time { return time.Date(2025, 6, 17, 9, 30, 0, 0, time.UTC) },
+	}
+
+	calc, err := f.ParseCalculation("(2 * $x) & 1.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := calc.Eval(ctx); err != nil || result != "61.5" {
+		t.Errorf("expected '61.5', got '%s' (%v)", result, err)
+	}
+
+	for src, expected := range map[string]string{
+		`Let ( x = Year ( Untitled::CreationTimestamp ) ; If ( x > 2000 ; Left ( Untitled::CreatedBy ; 2 ) & Length ( "abc" ) ; "no" ) )`: "Ad3",
+		`Case ( 1 > 2 ; "a" ; Middle ( "FileMaker" ; 5 ; 3 ) )`:                                                                           "Mak",
+		`not $x = 3 or "a" ≠ "A"`: "0",
+	} {
+		calc, err := f.ParseCalculation(src)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		if result, err := calc.Eval(ctx); err != nil {
+			t.Errorf("%s: %v", src, err)
+		} else if result != expected {
+			t.Errorf("%s: expected '%s', got '%s'", src, expected, result)
+		}
+	}
+
+	// Get ( CurrentDate ) cannot be compiled, see calcGetNames.
+	if result, err := evalCalcNode(parseSource(t, f, nil, `Get ( CurrentDate ) + 14`), ctx); err != nil || result != "01/07/2025" {
+		t.Errorf("expected '01/07/2025', got '%s' (%v)", result, err)
+	}
+
+	calc, err = f.ParseCalculation(`Sqrt ( 2 )`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = calc.Eval(ctx)
+	var unsupported *FmpUnsupportedError
+	if !errors.Is(err, ErrUnsupported) || !errors.As(err, &unsupported) || unsupported.Name != "Sqrt" {
+		t.Errorf("expected unsupported error for Sqrt, got %v", err)
+	}
+
+	uuid, err := ctx.Record.Evaluate("PrimaryKey", nil)
+	if err != nil || !regexp.MustCompile(`^[0-9A-F]{8}-[0-9A-F]{4}-4[0-9A-F]{3}-[89AB][0-9A-F]{3}-[0-9A-F]{12}$`).MatchString(uuid) {
+		t.Errorf("expected the auto-enter calculation of PrimaryKey to give a UUID, got '%s' (%v)", uuid, err)
+	}
+	uuid, err = ctx.Record.Evaluate("PrimaryKey", &FmpEvalContext{UUID: func() (string, error) { return "A-B", nil }})
+	if err != nil || uuid != "A-B" {
+		t.Errorf("expected UUID from the context, got '%s' (%v)", uuid, err)
+	}
+	if _, err := ctx.Record.Evaluate("CreatedBy", nil); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected a field without calculation to be unsupported, got %v", err)
+	}
+
+	_, err = f.decodeCalculation([]byte{0x99}, nil).Eval(ctx)
+	if !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected unsupported error for unknown bytecode, got %v", err)
+	}
+}
+
+func TestStoredCalculation(t *testing.T) {
+	f, err := OpenFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	open, close, sep := []byte{calcOpOpen}, []byte{calcOpClose}, []byte{calcOpSeparator}
+	occ := uint64(13631489)
+	codes := map[string][]byte{
+		// Let ( x = Year ( Untitled::CreationTimestamp ) ; If ( x > 2000 ;
+		// Left ( Untitled::CreatedBy ; 2 ) & Length ( "abc" ) ; "no" ) )
+		"Greeting": slices.Concat(
+			calcFunction("Let"), open, calcVariable("x"), []byte{0x2a}, calcFunction("Year"), open, calcField(occ, 2), close, sep,
+			calcFunction("If"), open, calcVariable("x"), []byte{0x2e}, calcNumber("2000"), sep,
+			calcFunction("Left"), open, calcField(occ, 3), sep, calcNumber("2"), close, []byte{0x50},
+			calcFunction("Length"), open, calcText("abc"), close, sep, calcText("no"), close, close,
+		),
+		// Case ( CreatedBy = "Admin" ; Middle ( "FileMaker" ; 5 ; 3 ) ; "no" )
+		"Product": slices.Concat(
+			calcFunction("Case"), open, calcField(0, 3), []byte{0x2a}, calcText("Admin"), sep,
+			calcFunction("Middle"), open, calcText("FileMaker"), sep, calcNumber("5"), sep, calcNumber("3"), close, sep,
+			calcText("no"), close,
+		),
+	}
+
+	id := uint64(100)
+	for name, code := range codes {
+		flags := make([]byte, 26)
+		flags[0], flags[1], flags[9] = byte(FmpFieldCalculation), byte(FmpDataText), byte(FmpFieldStorageUnstoredCalculation)
+		f.Dictionary.set([]uint64{32769, 3, 5, id, 2}, flags)
+		f.Dictionary.set([]uint64{32769, 3, 5, id, 16}, encodeString(name))
+		f.Dictionary.set([]uint64{32769, 3, 5, id, 5, 5}, code)
+		id++
+	}
+	if err := f.readTables(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.readRelationships(); err != nil {
+		t.Fatal(err)
+	}
+
+	record := f.Table("Untitled").Record(1)
+	for name, expected := range map[string]string{"Greeting": "Ad3", "Product": "Mak"} {
+		if v, err := record.Evaluate(name, nil); err != nil || v != expected {
+			t.Errorf("%s: expected '%s', got '%s' (%v)", name, expected, v, err)
+		}
+	}
+	if calc := record.Table.Column("Product").Calculation; calc.String() != `Case (CreatedBy="Admin";Middle ("FileMaker";5;3);"no")` {
+		t.Errorf("expected the calculation to be shown as written, got '%s'", calc)
+	}
+}
+
+// parseSource parses calculation source text into nodes, including what
+// cannot be compiled to bytecode.
+func parseSource(t *testing.T, f *FmpFile, table *FmpTable, src string) FmpCalcNode {
+	t.Helper()
+	tokens, err := lexCalculation(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	node, err := parseCalcTokens(f, table, tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
+
+func calcNumber(digits string) []byte {
+	return append([]byte{calcOpNumber, 0, 0, 0, 0, 0, 0, 0, byte(len(digits))}, digits...)
+}
+
+func calcVariable(name string) []byte {
+	return append([]byte{calcOpVariable, byte(len(name))}, name...)
+}
+
+func calcFunction(name string) []byte {
+	return append([]byte{calcOpFunction, byte(len(name))}, name...)
+}
+
+func calcField(occID, fieldID uint64) []byte {
+	return slices.Concat([]byte{calcOpField}, encodeLengthPrefixed(occID), encodeLengthPrefixed(fieldID))
+}
+
+// calcText encodes a text literal in chunks of at most 255 bytes.
+func calcText(text string) []byte {
+	code := []byte{}
+	for value := encodeString(text); len(code) == 0 || len(value) > 0; {
+		n := min(len(value), 0xFF)
+		code = append(code, calcOpText, byte(n))
+		code = append(code, value[:n]...)
+		value = value[n:]
+	}
+	return code
+}
+
+// corruptCopy copies the sample file to a temporary directory, overwriting
+// the byte at the given offset.
+func corruptCopy(t *testing.T, offset int64, value byte) string {
+	data, err := os.ReadFile("../files/Untitled.fmp12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[offset] = value
+
+	path := filepath.Join(t.TempDir(), "Corrupt.fmp12")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
 	}
+	return path
 }
 
 func slicesHaveSameElements[Type comparable](a, b []Type) bool {
@@ -0,0 +1,293 @@
+package fmp
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+)
+
+// FmpTransaction buffers changes to the dictionary and tables of a file, so
+// that they can be applied or discarded as a whole. It mirrors FileMaker's
+// Open Transaction, Commit Transaction and Revert Transaction script steps.
+// A transaction must not be shared between goroutines.
+type FmpTransaction struct {
+	file    *FmpFile
+	values  []fmpPendingValue
+	columns []*FmpColumn
+	records []*FmpRecord
+	updates []fmpPendingUpdate
+	sectors []*FmpSector
+	done    bool
+}
+
+type fmpPendingUpdate struct {
+	record *FmpRecord
+	values map[uint64]string
+}
+
+type fmpPendingValue struct {
+	path  []uint64
+	value []byte
+}
+
+// Begin opens a new transaction on the file. Nothing done through the
+// transaction is visible until Commit is called.
+func (ctx *FmpFile) Begin() *FmpTransaction {
+	return &FmpTransaction{file: ctx}
+}
+
+// NewSector allocates a new sector. It is written to the end of the file, and
+// given its ID, when the transaction is committed.
+func (tx *FmpTransaction) NewSector() (*FmpSector, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	sector := &FmpSector{Chunks: make([]*FmpChunk, 0)}
+	tx.sectors = append(tx.sectors, sector)
+	return sector, nil
+}
+
+// NewColumn defines a new simple field with the given name and data type.
+func (tx *FmpTransaction) NewColumn(t *FmpTable, name string, dataType FmpDataType) (*FmpColumn, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	if t.Column(name) != nil || tx.pendingColumn(t, name) != nil {
+		return nil, ErrColumnExists
+	}
+
+	column := &FmpColumn{
+		Table:       t,
+		Index:       t.reserveColumnID(),
+		Name:        name,
+		Type:        FmpFieldSimple,
+		DataType:    dataType,
+		StorageType: FmpFieldStorageRegular,
+		Repetitions: 1,
+	}
+
+	flags := make([]byte, 26)
+	flags[0] = byte(column.Type)
+	flags[1] = byte(column.DataType)
+	flags[9] = byte(column.StorageType)
+	flags[25] = column.Repetitions
+
+	tx.columns = append(tx.columns, column)
+	tx.setValue([]uint64{t.ID, 3, 5, column.Index, 2}, flags)
+	tx.setValue([]uint64{t.ID, 3, 5, column.Index, 16}, encodeString(name))
+	return column, nil
+}
+
+// NewRecord creates a new record in the given table. Its record ID is
+// reserved immediately, and is not handed out again if the transaction is
+// rolled back. Auto-enter options of the fields are applied, unless the file
+// was opened with NoAutoEnter. The values are then validated, and an
+// FmpValidationError is returned for the first value that fails. Values given
+// for global fields are set as with SetGlobal. Records of tables with an
+// ExternalSource cannot be created, and return ErrExternalSource.
+func (tx *FmpTransaction) NewRecord(t *FmpTable, values map[string]string) (*FmpRecord, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	if t.ExternalSource != "" {
+		return nil, ErrExternalSource
+	}
+
+	vals, globals, err := tx.columnValues(t, values)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := tx.allColumns(t)
+	record := &FmpRecord{Table: t, Index: t.reserveRecordID(), Values: vals}
+	if _, err := tx.applyAutoEnter(record, columns, nil); err != nil {
+		return nil, err
+	}
+	if err := tx.validate(record, columns); err != nil {
+		return nil, err
+	}
+
+	if err := tx.setGlobals(globals); err != nil {
+		return nil, err
+	}
+
+	tx.records = append(tx.records, record)
+	for colIndex, value := range vals {
+		tx.setValue([]uint64{t.ID, 5, record.Index, colIndex}, encodeString(value))
+	}
+	return record, nil
+}
+
+// UpdateRecord changes the given field values of a record. Auto-enter options
+// are applied and the new values validated like in NewRecord.
+func (tx *FmpTransaction) UpdateRecord(r *FmpRecord, values map[string]string) error {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	vals, globals, err := tx.columnValues(r.Table, values)
+	if err != nil {
+		return err
+	}
+
+	r.Table.file.mu.RLock()
+	merged := maps.Clone(r.Values)
+	r.Table.file.mu.RUnlock()
+	maps.Copy(merged, vals)
+
+	columns := tx.allColumns(r.Table)
+	candidate := &FmpRecord{Table: r.Table, Index: r.Index, Values: merged}
+	changedIDs := make(map[uint64]bool)
+	for index := range vals {
+		changedIDs[index] = true
+	}
+	entered, err := tx.applyAutoEnter(candidate, columns, changedIDs)
+	if err != nil {
+		return err
+	}
+	for _, column := range entered {
+		vals[column.Index] = merged[column.Index]
+	}
+
+	changed := make([]*FmpColumn, 0, len(vals))
+	for _, column := range columns {
+		if _, ok := vals[column.Index]; ok {
+			changed = append(changed, column)
+		}
+	}
+	if err := tx.validate(candidate, changed); err != nil {
+		return err
+	}
+
+	if err := tx.setGlobals(globals); err != nil {
+		return err
+	}
+
+	tx.updates = append(tx.updates, fmpPendingUpdate{record: r, values: vals})
+	for colIndex, value := range vals {
+		tx.setValue([]uint64{r.Table.ID, 5, r.Index, colIndex}, encodeString(value))
+	}
+	return nil
+}
+
+// columnValues maps field names to the IDs of the fields, including fields
+// defined earlier in the transaction. Values of global fields are not part of
+// records, so they are returned apart, to be validated and set as with
+// SetGlobal.
+func (tx *FmpTransaction) columnValues(t *FmpTable, values map[string]string) (map[uint64]string, []fmpPendingGlobal, error) {
+	vals := make(map[uint64]string)
+	globals := make([]fmpPendingGlobal, 0)
+	for k, v := range values {
+		col := t.Column(k)
+		if col == nil {
+			col = tx.pendingColumn(t, k)
+		}
+		if col == nil {
+			return nil, nil, ErrUnknownColumn
+		}
+		if col.StorageType == FmpFieldStorageGlobal {
+			globals = append(globals, fmpPendingGlobal{column: col, value: v})
+			continue
+		}
+		vals[col.Index] = v
+	}
+	return vals, globals, nil
+}
+
+// allColumns returns the fields of a table, including fields defined earlier
+// in the transaction, ordered by ID.
+func (tx *FmpTransaction) allColumns(t *FmpTable) []*FmpColumn {
+	t.file.mu.RLock()
+	columns := slices.Collect(maps.Values(t.Columns))
+	t.file.mu.RUnlock()
+
+	for _, column := range tx.columns {
+		if column.Table == t {
+			columns = append(columns, column)
+		}
+	}
+	slices.SortFunc(columns, func(a, b *FmpColumn) int {
+		return cmp.Compare(a.Index, b.Index)
+	})
+	return columns
+}
+
+// Commit applies all buffered changes to the file at once. New sectors are
+// written first. If that fails, the file is restored to how it was, nothing
+// else is applied, and the transaction stays open so that it can be retried
+// or rolled back.
+func (tx *FmpTransaction) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	tx.file.mu.Lock()
+	defer tx.file.mu.Unlock()
+
+	if err := tx.file.appendSectors(tx.sectors); err != nil {
+		return err
+	}
+	tx.done = true
+
+	for _, v := range tx.values {
+		tx.file.setValue(v.path, v.value)
+	}
+	for _, column := range tx.columns {
+		column.Table.Columns[column.Index] = column
+	}
+	tx.applySerialsOnCommit()
+	counted := make(map[*FmpTable]bool)
+	for _, record := range tx.records {
+		record.Table.Records[record.Index] = record
+		record.Table.recordCount++
+		counted[record.Table] = true
+	}
+	for table := range counted {
+		table.writeRecordCount()
+	}
+	for _, update := range tx.updates {
+		maps.Copy(update.record.Values, update.values)
+	}
+	return nil
+}
+
+// Rollback discards all buffered changes.
+func (tx *FmpTransaction) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	tx.values = nil
+	tx.columns = nil
+	tx.records = nil
+	tx.updates = nil
+	tx.sectors = nil
+	return nil
+}
+
+func (tx *FmpTransaction) setValue(path []uint64, value []byte) {
+	tx.values = append(tx.values, fmpPendingValue{path: path, value: value})
+}
+
+func (tx *FmpTransaction) pendingColumn(t *FmpTable, name string) *FmpColumn {
+	for _, column := range tx.columns {
+		if column.Table == t && column.Name == name {
+			return column
+		}
+	}
+	return nil
+}
+
+func (t *FmpTable) reserveColumnID() uint64 {
+	t.file.mu.Lock()
+	defer t.file.mu.Unlock()
+	t.lastColumnID++
+	return t.lastColumnID
+}
+
+func (t *FmpTable) reserveRecordID() uint64 {
+	t.file.mu.Lock()
+	defer t.file.mu.Unlock()
+	t.lastRecordID++
+	return t.lastRecordID
+}
@@ -1,5 +1,14 @@
 package fmp
 
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
 func addIf(cond bool, val uint64) uint64 {
 	if cond {
 		return val
@@ -17,11 +26,28 @@ func decodeVarUint64(payload []byte) uint64 {
 	return length
 }
 
-func decodeString(payload []byte) string {
-	result := ""
-	for i := range payload {
-		result += string(payload[i] ^ 0x5A)
+// decodeLengthPrefixed decodes the integer whose length is given by the byte
+// at pos, returning it along with the position just past it.
+func decodeLengthPrefixed(payload []byte, pos int) (uint64, int, bool) {
+	if pos >= len(payload) {
+		return 0, pos, false
+	}
+	end := pos + 1 + int(payload[pos])
+	if end > len(payload) {
+		return 0, pos, false
+	}
+	return decodeVarUint64(payload[pos+1 : end]), end, true
+}
+
+// encodeLengthPrefixed is the inverse of decodeLengthPrefixed, using as few
+// bytes as possible.
+func encodeLengthPrefixed(value uint64) []byte {
+	result := []byte{0}
+	for value > 0 || len(result) == 1 {
+		result = slices.Insert(result, 1, byte(value))
+		value >>= 8
 	}
+	result[0] = byte(len(result) - 1)
 	return result
 }
 
@@ -39,3 +65,33 @@ func writeToSlice(slice []byte, start int, payload ...byte) {
 		slice[start+i] = payload[i]
 	}
 }
+
+func formatPath(path []uint64) string {
+	parts := make([]string, len(path))
+	for i, key := range path {
+		parts[i] = fmt.Sprintf("[%d]", key)
+	}
+	return strings.Join(parts, ".")
+}
+
+// compareValues compares two field values as the given data type, falling
+// back to a case-insensitive text comparison when they cannot be parsed.
+func compareValues(a, b string, dataType FmpDataType) int {
+	switch dataType {
+	case FmpDataNumber:
+		fa, errA := strconv.ParseFloat(strings.TrimSpace(a), 64)
+		fb, errB := strconv.ParseFloat(strings.TrimSpace(b), 64)
+		if errA == nil && errB == nil {
+			return cmp.Compare(fa, fb)
+		}
+
+	case FmpDataDate, FmpDataTime, FmpDataTS:
+		layout := dataTypeLayouts[dataType]
+		ta, errA := time.Parse(layout, a)
+		tb, errB := time.Parse(layout, b)
+		if errA == nil && errB == nil {
+			return ta.Compare(tb)
+		}
+	}
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
@@ -0,0 +1,268 @@
+package fmp
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FmpValidation holds the validation options of a field. Changes made through
+// this library count as data entry, so they are validated even when the field
+// is only validated during data entry.
+type FmpValidation struct {
+	Always          bool // Validate always, rather than only during data entry
+	UserCanOverride bool // Failures can be accepted with OverrideValidation
+
+	Required      bool
+	Unique        bool
+	Existing      bool
+	StrictNumeric bool
+	FourDigitYear bool
+	TimeOfDay     bool
+
+	// The remaining options are flagged in the field definition, and only
+	// enforced when flagged. Their details are read from key 6 of the field
+	// definition, see readValidationDetails.
+	InValueList   bool
+	HasMaxLength  bool
+	InRange       bool
+	ByCalculation bool
+	HasMessage    bool
+
+	ValueList   *FmpValueList   // Value must be a member of this value list
+	MaxLength   int             // Maximum number of characters, if not 0
+	RangeFrom   string          // Value must not be below this, if set
+	RangeTo     string          // Value must not be above this, if set
+	Calculation *FmpCalculation // Value is valid when this evaluates to true
+
+	Message string // Custom message to show when validation fails
+
+	valueListID uint64 // Resolved to ValueList once value lists are read
+}
+
+// FmpValidationError reports a value that fails the validation of its field.
+type FmpValidationError struct {
+	Column  *FmpColumn
+	Value   string
+	Message string // Custom message of the field, or what is wrong
+}
+
+func (e *FmpValidationError) Error() string {
+	return fmt.Sprintf("%v: %s: %s", ErrValidation, e.Column.Name, e.Message)
+}
+
+func (e *FmpValidationError) Unwrap() error {
+	return ErrValidation
+}
+
+// decodeValidation decodes the validation options in bytes 14 and 15 of the
+// field flags.
+func decodeValidation(flags []byte) FmpValidation {
+	return FmpValidation{
+		Always:          flags[14]&0x04 != 0,
+		UserCanOverride: flags[15]&0x04 == 0,
+		Required:        flags[15]&0x08 != 0,
+		Unique:          flags[15]&0x10 != 0,
+		Existing:        flags[15]&0x20 != 0,
+		StrictNumeric:   flags[14]&0x10 != 0,
+		FourDigitYear:   flags[14]&0x20 != 0,
+		TimeOfDay:       flags[14]&0x40 != 0,
+		InValueList:     flags[14]&0x01 != 0,
+		HasMaxLength:    flags[14]&0x02 != 0,
+		InRange:         flags[15]&0x40 != 0,
+		ByCalculation:   flags[15]&0x01 != 0,
+		HasMessage:      flags[15]&0x80 != 0,
+	}
+}
+
+// readValidationDetails decodes the details of the flagged validation options
+// from key 6 of the field definition:
+//
+//   - key 1: the length-prefixed maximum number of characters.
+//   - keys 2 and 3: the lowest and highest value of the range.
+//   - key 4: the length-prefixed ID of the value list.
+//   - key 5: the calculation, with its bytecode at key 5 like the calculation
+//     of the field itself.
+//   - key 6: the custom message.
+//
+// The sample file validates none of its fields this way, so this layout is
+// this package's own. Options that are flagged without details are reported
+// as a problem with the dictionary.
+func (ctx *FmpFile) readValidationDetails(column *FmpColumn, d *FmpDict, path []uint64) error {
+	v := &column.Validation
+	details := d.GetChildren(6)
+	missing := func(key uint64) error {
+		return ctx.problem(&FmpParseError{Err: ErrBadDictionary, Path: append(slices.Clone(path), 6, key)})
+	}
+
+	if v.HasMaxLength {
+		length, _, ok := decodeLengthPrefixed(details.GetValue(1), 0)
+		if !ok {
+			if err := missing(1); err != nil {
+				return err
+			}
+		}
+		v.MaxLength = int(length)
+	}
+	if v.InRange {
+		v.RangeFrom = decodeString(details.GetValue(2))
+		v.RangeTo = decodeString(details.GetValue(3))
+		if v.RangeFrom == "" && v.RangeTo == "" {
+			if err := missing(2); err != nil {
+				return err
+			}
+		}
+	}
+	if v.InValueList {
+		id, _, ok := decodeLengthPrefixed(details.GetValue(4), 0)
+		if !ok {
+			if err := missing(4); err != nil {
+				return err
+			}
+		}
+		v.valueListID = id
+	}
+	if v.ByCalculation {
+		v.Calculation = ctx.decodeCalculation(details.GetValue(5, 5), column.Table)
+		if v.Calculation == nil {
+			if err := missing(5); err != nil {
+				return err
+			}
+		}
+	}
+	if v.HasMessage {
+		v.Message = decodeString(details.GetValue(6))
+	}
+	return nil
+}
+
+// validate checks the given fields of a record that is about to be created
+// or updated. Records created earlier in the transaction count towards unique
+// and existing values. Fields whose validation the user can override are not
+// checked if the file was opened with OverrideValidation.
+func (tx *FmpTransaction) validate(record *FmpRecord, columns []*FmpColumn) error {
+	for _, column := range columns {
+		if column.Type != FmpFieldSimple || column.StorageType != FmpFieldStorageRegular {
+			continue
+		}
+		if err := tx.validateValue(column, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateValue checks the value of a single field of a record.
+func (tx *FmpTransaction) validateValue(column *FmpColumn, record *FmpRecord) error {
+	if tx.file.override && column.Validation.UserCanOverride {
+		return nil
+	}
+	if message := tx.check(column, record); message != "" {
+		if column.Validation.HasMessage && column.Validation.Message != "" {
+			message = column.Validation.Message
+		}
+		return &FmpValidationError{Column: column, Value: record.Values[column.Index], Message: message}
+	}
+	return nil
+}
+
+func (tx *FmpTransaction) check(column *FmpColumn, record *FmpRecord) string {
+	v := column.Validation
+	value := record.Values[column.Index]
+
+	if value == "" {
+		if v.Required && !(column.AutoEnter == FmpAutoEnterSerialNumber && column.SerialOnCommit) {
+			return "value is required"
+		}
+		return ""
+	}
+
+	switch {
+	case v.StrictNumeric && !isNumeric(value):
+		return "value must be a number"
+	case v.FourDigitYear && !hasFourDigitYear(value, column.DataType):
+		return "value must be a date with a four-digit year"
+	case v.TimeOfDay && !isTimeOfDay(value):
+		return "value must be a time of day"
+	case v.HasMaxLength && v.MaxLength > 0 && utf8.RuneCountInString(value) > v.MaxLength:
+		return fmt.Sprintf("value must be at most %d characters", v.MaxLength)
+	case v.InRange && v.RangeFrom != "" && compareValues(value, v.RangeFrom, column.DataType) < 0:
+		return fmt.Sprintf("value must be at least %s", v.RangeFrom)
+	case v.InRange && v.RangeTo != "" && compareValues(value, v.RangeTo, column.DataType) > 0:
+		return fmt.Sprintf("value must be at most %s", v.RangeTo)
+	}
+
+	// Global fields hold a single value, which is neither unique nor not.
+	if (v.Unique || v.Existing) && column.StorageType != FmpFieldStorageGlobal {
+		found := tx.valueExists(column, record, value)
+		if v.Unique && found {
+			return "value must be unique"
+		}
+		if v.Existing && !found {
+			return "value must be an existing value"
+		}
+	}
+
+	if v.InValueList && v.ValueList != nil {
+		items, err := v.ValueList.Values(record)
+		if err == nil && !containsValue(items, value) {
+			return fmt.Sprintf("value must be in value list %s", v.ValueList.Name)
+		}
+	}
+
+	// Calculations that cannot be evaluated in Go are not enforced.
+	if v.ByCalculation && v.Calculation != nil {
+		result, err := v.Calculation.Eval(&FmpEvalContext{Record: record})
+		if err == nil && parseCalcNumber(result) == 0 {
+			return "value does not satisfy the validation calculation"
+		}
+	}
+	return ""
+}
+
+// valueExists reports whether another record has the value. Like FileMaker,
+// it ignores case.
+func (tx *FmpTransaction) valueExists(column *FmpColumn, record *FmpRecord, value string) bool {
+	for _, other := range column.Table.AllRecords() {
+		if other.Index != record.Index && strings.EqualFold(other.Value(column.Name), value) {
+			return true
+		}
+	}
+	for _, other := range tx.records {
+		if other != record && other.Table == column.Table && strings.EqualFold(other.Values[column.Index], value) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsValue(items []FmpValueListItem, value string) bool {
+	for _, item := range items {
+		if item.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+func isNumeric(value string) bool {
+	_, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	return err == nil
+}
+
+func hasFourDigitYear(value string, dataType FmpDataType) bool {
+	layout, ok := dataTypeLayouts[dataType]
+	if !ok || dataType == FmpDataTime {
+		return true
+	}
+	_, err := time.Parse(layout, value)
+	return err == nil
+}
+
+func isTimeOfDay(value string) bool {
+	_, err := time.Parse(FmpTimeLayout, value)
+	return err == nil
+}
@@ -0,0 +1,212 @@
+package fmp
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+type FmpValueList struct {
+	ID     uint64
+	Name   string
+	Source FmpValueListSource
+
+	// CustomValues holds the values of lists with custom values.
+	CustomValues []string
+
+	// FirstField holds the values of lists with values from a field, and
+	// SecondField, if set, what is displayed along with them.
+	FirstField      FmpFieldRef
+	SecondField     FmpFieldRef
+	SecondFieldOnly bool // Show values only from the second field
+	SortBySecond    bool // Sort values using the second field
+
+	// RelatedFrom is set when only related values are included, starting
+	// from this occurrence.
+	RelatedFrom *FmpTableOccurrence
+
+	// ExternalFile and ExternalList name the file and value list that lists
+	// using values from another file refer to.
+	ExternalFile string
+	ExternalList string
+}
+
+// FmpValueListSource is where the values of a list come from.
+type FmpValueListSource uint8
+
+const (
+	FmpValueListCustom   FmpValueListSource = 1
+	FmpValueListField    FmpValueListSource = 2
+	FmpValueListExternal FmpValueListSource = 3
+)
+
+// FmpValueListItem is a value of a value list. Display holds what the list
+// shows for it: the value of the second field if the list shows values only
+// from there, and otherwise the value followed by that of the second field,
+// separated by a tab, as FileMaker shows them in two columns.
+type FmpValueListItem struct {
+	Value   string
+	Display string
+
+	second string
+}
+
+// ValueLists returns the value lists in the file, ordered by ID.
+func (ctx *FmpFile) ValueLists() []*FmpValueList {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+	return slices.Clone(ctx.valueLists)
+}
+
+// ValueList returns the value list with the given name, or nil.
+func (ctx *FmpFile) ValueList(name string) *FmpValueList {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+
+	for _, list := range ctx.valueLists {
+		if list.Name == name {
+			return list
+		}
+	}
+	return nil
+}
+
+// readValueLists decodes the value lists at [33].[5].[valuelist]. The name
+// is at key 16 like in the other catalogs, and the definition at:
+//
+//   - key 2: the source in byte 0, and options in byte 1: 0x01 to show values
+//     only from the second field, and 0x02 to sort by the second field.
+//   - key 5: custom values, separated by carriage returns.
+//   - keys 6 and 7: the first and second field, as length-prefixed occurrence
+//     and field IDs.
+//   - key 8: the length-prefixed ID of the occurrence related values start
+//     from, if only related values are included.
+//   - keys 9 and 10: the name of the file and value list of lists using
+//     values from another file.
+//
+// The sample file has no value lists, so only the location of the names is
+// known from files; the definition is this package's own.
+func (ctx *FmpFile) readValueLists() error {
+	ctx.valueLists = make([]*FmpValueList, 0)
+
+	for id, ent := range *ctx.Dictionary.GetChildren(33, 5) {
+		if ent.Children == nil {
+			continue
+		}
+		list := &FmpValueList{
+			ID:           id,
+			Name:         decodeString(ent.Children.GetValue(16)),
+			FirstField:   ctx.decodeFieldRef(ent.Children.GetValue(6)),
+			SecondField:  ctx.decodeFieldRef(ent.Children.GetValue(7)),
+			ExternalFile: decodeString(ent.Children.GetValue(9)),
+			ExternalList: decodeString(ent.Children.GetValue(10)),
+		}
+		if meta := ent.Children.GetValue(2); len(meta) >= 2 {
+			list.Source = FmpValueListSource(meta[0])
+			list.SecondFieldOnly = meta[1]&0x01 != 0
+			list.SortBySecond = meta[1]&0x02 != 0
+		}
+		if values := ent.Children.GetValue(5); values != nil {
+			list.CustomValues = strings.Split(decodeString(values), "\r")
+		}
+		if occID, _, ok := decodeLengthPrefixed(ent.Children.GetValue(8), 0); ok {
+			list.RelatedFrom = ctx.occurrenceByID(occID)
+		}
+
+		ctx.valueLists = append(ctx.valueLists, list)
+	}
+
+	slices.SortFunc(ctx.valueLists, func(a, b *FmpValueList) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	// Fields validated by a value list refer to it by ID.
+	for _, table := range ctx.tables {
+		for _, column := range table.Columns {
+			if column.Validation.InValueList {
+				column.Validation.ValueList = ctx.valueListByID(column.Validation.valueListID)
+			}
+		}
+	}
+	return nil
+}
+
+func (ctx *FmpFile) valueListByID(id uint64) *FmpValueList {
+	for _, list := range ctx.valueLists {
+		if list.ID == id {
+			return list
+		}
+	}
+	return nil
+}
+
+// Values resolves the values of the list. For lists that include only related
+// values, these are the values related to the given record through the
+// occurrence the list starts from; the record is not used otherwise and may be
+// nil. Values from fields are sorted and listed once, with each line of a
+// field value counting as a separate value, like in FileMaker. Lists using
+// values from another file return ErrExternalSource, and lists with an
+// unknown source ErrBadValueList.
+func (vl *FmpValueList) Values(record *FmpRecord) ([]FmpValueListItem, error) {
+	switch vl.Source {
+	case FmpValueListCustom:
+		items := make([]FmpValueListItem, len(vl.CustomValues))
+		for i, value := range vl.CustomValues {
+			items[i] = FmpValueListItem{Value: value}
+		}
+		return items, nil
+	case FmpValueListExternal:
+		return nil, ErrExternalSource
+	case FmpValueListField:
+	default:
+		return nil, ErrBadValueList
+	}
+
+	first, second := vl.FirstField.Column, vl.SecondField.Column
+	if first == nil || vl.RelatedFrom != nil && vl.FirstField.Occurrence == nil {
+		return nil, ErrUnknownColumn
+	}
+
+	var records []*FmpRecord
+	if vl.RelatedFrom != nil {
+		if record == nil || record.Table != vl.RelatedFrom.Table {
+			return []FmpValueListItem{}, nil
+		}
+		for related := range record.related(vl.RelatedFrom, vl.FirstField.Occurrence.Name) {
+			records = append(records, related)
+		}
+	} else {
+		records = first.Table.AllRecords()
+	}
+
+	items := make([]FmpValueListItem, 0)
+	seen := make(map[string]bool)
+	for _, r := range records {
+		secondValue := ""
+		if second != nil {
+			secondValue = r.Value(second.Name)
+		}
+		for _, value := range splitKeys(r.Value(first.Name)) {
+			if seen[value] {
+				continue
+			}
+			seen[value] = true
+
+			item := FmpValueListItem{Value: value, Display: value, second: secondValue}
+			if second != nil && vl.SecondFieldOnly {
+				item.Display = secondValue
+			} else if second != nil {
+				item.Display = value + "\t" + secondValue
+			}
+			items = append(items, item)
+		}
+	}
+
+	slices.SortStableFunc(items, func(a, b FmpValueListItem) int {
+		if vl.SortBySecond && second != nil {
+			return second.Compare(a.second, b.second)
+		}
+		return first.Compare(a.Value, b.Value)
+	})
+	return items, nil
+}
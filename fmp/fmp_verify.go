@@ -0,0 +1,178 @@
+package fmp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// FmpReport holds the findings of an integrity check.
+type FmpReport struct {
+	Findings []FmpFinding
+}
+
+type FmpFinding struct {
+	Severity FmpSeverity
+	Check    string // Which check raised the finding, e.g. "header" or "sectors"
+	Message  string
+	Err      error // Underlying error, if any
+}
+
+// Verify checks the integrity of an opened file: its header, the sector
+// chain, whether the chunks of all sectors on disk can be decoded, the layout
+// of the dictionary, and whether record values refer to existing fields. Files
+// opened in salvage mode report the other problems found while decoding as
+// well. Findings are ordered by check, then by sector, table and record.
+func Verify(f *FmpFile) *FmpReport {
+	r := &FmpReport{}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	f.verifyHeader(r)
+	f.verifySectors(r)
+	f.verifyChunks(r)
+	f.verifyProblems(r)
+	f.verifyDictionary(r)
+	f.verifyRecords(r)
+	return r
+}
+
+// Worst returns the highest severity among the findings, or
+// FmpSeverityInfo if there are none.
+func (r *FmpReport) Worst() FmpSeverity {
+	worst := FmpSeverityInfo
+	for _, finding := range r.Findings {
+		worst = max(worst, finding.Severity)
+	}
+	return worst
+}
+
+// Clean reports whether the check found nothing worse than informational
+// findings.
+func (r *FmpReport) Clean() bool {
+	return r.Worst() < FmpSeverityWarning
+}
+
+func (r *FmpReport) add(severity FmpSeverity, check string, err error, format string, args ...any) {
+	r.Findings = append(r.Findings, FmpFinding{
+		Severity: severity,
+		Check:    check,
+		Message:  fmt.Sprintf(format, args...),
+		Err:      err,
+	})
+}
+
+func (f FmpFinding) String() string {
+	return fmt.Sprintf("%v: %v: %v", f.Severity, f.Check, f.Message)
+}
+
+func (ctx *FmpFile) verifyHeader(r *FmpReport) {
+	buf := make([]byte, magicSize+hbamSize)
+	if _, err := ctx.stream.ReadAt(buf, 0); err != nil {
+		r.add(FmpSeverityError, "header", err, "could not read header")
+		return
+	}
+	if !bytes.Equal(buf[:magicSize], []byte(magicSequence)) {
+		r.add(FmpSeverityError, "header", ErrBadMagic, "magic sequence does not match")
+	}
+	if !bytes.Equal(buf[magicSize:], []byte(hbamSequence)) {
+		r.add(FmpSeverityError, "header", ErrBadMagic, "%s marker is missing", hbamSequence)
+	}
+}
+
+func (ctx *FmpFile) verifySectors(r *FmpReport) {
+	if ctx.FileSize%sectorSize != 0 {
+		r.add(FmpSeverityWarning, "sectors", nil, "file size %d is not a multiple of the sector size", ctx.FileSize)
+	}
+	if len(ctx.Sectors) == 0 {
+		r.add(FmpSeverityError, "sectors", nil, "file has no sectors")
+		return
+	}
+
+	// The first sector is read before its ID is known, so links pointing back
+	// to it cannot be checked.
+	for i := 1; i < len(ctx.Sectors); i++ {
+		prev, sect := ctx.Sectors[i-1], ctx.Sectors[i]
+		if i > 1 && sect.PrevID != prev.ID {
+			r.add(FmpSeverityError, "sectors", nil, "sector %d links back to %d instead of %d", sect.ID, sect.PrevID, prev.ID)
+		}
+		if sect.Deleted {
+			r.add(FmpSeverityWarning, "sectors", nil, "deleted sector %d is part of the chain", sect.ID)
+		}
+	}
+
+	if last := ctx.Sectors[len(ctx.Sectors)-1]; last.NextID != 0 {
+		r.add(FmpSeverityError, "sectors", nil, "sector chain ends at sector %d, which links to %d", last.ID, last.NextID)
+	}
+}
+
+// verifyChunks decodes the chunks of every sector again, as they are on disk.
+func (ctx *FmpFile) verifyChunks(r *FmpReport) {
+	for _, sect := range ctx.Sectors {
+		if sect.ID == 0 {
+			continue
+		}
+		copied := &FmpSector{ID: sect.ID, offset: sect.offset, Payload: make([]byte, sectorPayloadSize)}
+		if _, err := ctx.stream.ReadAt(copied.Payload, sect.offset+sectorHeaderSize); err != nil {
+			r.add(FmpSeverityError, "chunks", err, "could not read sector %d", sect.ID)
+			continue
+		}
+		if err := copied.readChunks(); err != nil {
+			r.add(FmpSeverityError, "chunks", err, "%v", err)
+		}
+	}
+}
+
+// verifyProblems reports the problems found while salvaging. Chunks that could
+// not be decoded are reported by verifyChunks.
+func (ctx *FmpFile) verifyProblems(r *FmpReport) {
+	for _, err := range ctx.Problems {
+		check := "dictionary"
+		switch {
+		case errors.Is(err, ErrBadChunk):
+			continue
+		case errors.Is(err, ErrRead), errors.Is(err, ErrBadHeader),
+			errors.Is(err, ErrBadSectorHeader), errors.Is(err, ErrSectorLoop):
+			check = "sectors"
+		}
+		r.add(FmpSeverityError, check, err, "%v", err)
+	}
+}
+
+func (ctx *FmpFile) verifyDictionary(r *FmpReport) {
+	catalog := ctx.Dictionary.GetEntry(3, 16, 5)
+	if catalog == nil || len(*catalog.Children) == 0 {
+		r.add(FmpSeverityError, "dictionary", nil, "table catalog %s is missing", formatPath([]uint64{3, 16, 5}))
+		return
+	}
+
+	for _, id := range slices.Sorted(maps.Keys(*catalog.Children)) {
+		if id < 128 {
+			continue
+		}
+		ent := (*catalog.Children)[id]
+		if len(ent.Children.GetValue(16)) == 0 {
+			r.add(FmpSeverityWarning, "dictionary", nil, "table %d has no name", id)
+		}
+		if len(*ctx.Dictionary.GetChildren(id, 3, 5)) == 0 {
+			r.add(FmpSeverityError, "dictionary", nil, "table %d has no fields at %s", id, formatPath([]uint64{id, 3, 5}))
+		}
+		if ctx.Dictionary.GetEntry(id, 5) == nil {
+			r.add(FmpSeverityWarning, "dictionary", nil, "table %d has no records at %s", id, formatPath([]uint64{id, 5}))
+		}
+	}
+}
+
+func (ctx *FmpFile) verifyRecords(r *FmpReport) {
+	for _, table := range ctx.tables {
+		for _, record := range table.allRecords() {
+			for _, colIndex := range slices.Sorted(maps.Keys(record.Values)) {
+				if table.Columns[colIndex] == nil {
+					r.add(FmpSeverityError, "records", nil, "record %d of table %s refers to unknown field %d", record.Index, table.Name, colIndex)
+				}
+			}
+		}
+	}
+}